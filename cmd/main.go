@@ -1,53 +1,377 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/outbox-go-sdk/internal/claimcheck"
+	"github.com/outbox-go-sdk/internal/config"
+	"github.com/outbox-go-sdk/internal/db/mysql"
 	db "github.com/outbox-go-sdk/internal/db/postgres"
+	"github.com/outbox-go-sdk/internal/db/sqlite"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/metrics"
 	"github.com/outbox-go-sdk/internal/outbox/handler"
 	"github.com/outbox-go-sdk/internal/outbox/service"
 	"github.com/outbox-go-sdk/internal/publisher/nats"
+
+	natsgo "github.com/nats-io/nats.go"
 )
 
 func main() {
-	// Initialize DB config (use existing DB instance or provide connection params)
-	dbConfig := &db.Config{
-		User:     "postgres",     // Use the user from docker-compose.yml
-		Password: "rootpassword", // Use the password from docker-compose.yml
-		Host:     "postgres",     // Use the service name from docker-compose.yml
-		Port:     5432,           // Default PostgreSQL port
-		DBName:   "transactional_outbox",
-		SSLMode:  "disable", // Optional: default is "disable"
+	// "migrate" applies the outbox schema migrations out-of-band and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
+	// "reencrypt" rotates encrypted messages to the current encryption key and exits
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		reencrypt(os.Args[2:])
+		return
 	}
 
-	// Initialize NATS config (use existing NATS connection or provide URL)
-	natsConfig := &nats.Config{
-		URL: "nats://nats:4222", // Use the NATS URL
+	// "stats" prints message counts by tenant and status and exits
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		stats(os.Args[2:])
+		return
+	}
+
+	// Load the configuration from the config file, environment variables and flags
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
 	}
 
+	// Stop relaying after the current batches on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize Repositories with Config structs
-	dbRepo, err := db.NewGormRepository(dbConfig)
+	dbRepo, err := newRepository(cfg)
 	if err != nil {
 		log.Fatalf("Error initializing DB: %v", err)
 	}
 
-	ncRepo, err := nats.NewNatsPublisher(natsConfig)
+	ncRepo, err := nats.NewNatsPublisher(cfg.NATSConfig())
 	if err != nil {
 		log.Fatalf("Error initializing NATS: %v", err)
 	}
 
+	// Count published, failed and expired messages, served as expvars when an address is configured
+	counters := metrics.NewCounters()
+	counters.Publish("outbox")
+	if cfg.Relay.MetricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/debug/vars", expvar.Handler())
+			log.Printf("Metrics server stopped: %v", http.ListenAndServe(cfg.Relay.MetricsAddr, mux))
+		}()
+	}
+
 	// Initialize Service with the repositories
-	outboxService := service.NewService(dbRepo, ncRepo, 100)
+	opts := []service.Option{service.WithRetryPolicy(retryPolicy(cfg)), service.WithMetrics(counters)}
+	encryptor, err := cfg.Encryptor()
+	if err != nil {
+		log.Fatalf("Error initializing encryption: %v", err)
+	}
+	if encryptor != nil {
+		opts = append(opts, service.WithEncryption(encryptor))
+	}
+	if cc := cfg.CompressionConfig(); cc != nil {
+		opts = append(opts, service.WithCompression(*cc))
+	}
+	if ce := cfg.CloudEventsConfig(); ce != nil {
+		opts = append(opts, service.WithCloudEvents(*ce))
+	}
+	if cfg.Relay.Partitions > 0 {
+		coordinator, err := newPartitionCoordinator(cfg)
+		if err != nil {
+			log.Fatalf("Error initializing sharding: %v", err)
+		}
+		opts = append(opts, service.WithPartitions(coordinator))
+		// Hand the partitions over to the other instances on shutdown instead of waiting for the leases to expire
+		defer func() {
+			if err := coordinator.Leave(); err != nil {
+				log.Printf("Error releasing partitions: %v", err)
+			}
+		}()
+	}
+	if cfg.Relay.LeaseTTL > 0 {
+		ttl := time.Duration(cfg.Relay.LeaseTTL)
+		opts = append(opts, service.WithLeases(service.LeaseConfig{Owner: cfg.InstanceID(), TTL: ttl}))
+		go service.RunReaper(ctx, dbRepo, ttl/2)
+	}
+	if cfg.Relay.TenantFairness {
+		opts = append(opts, service.WithTenantFairness())
+	}
+	if cfg.Relay.TenantSubjectPrefix != "" {
+		opts = append(opts, service.WithTenantSubjectPrefix(cfg.Relay.TenantSubjectPrefix))
+	}
+	if cfg.Relay.Pipeline {
+		opts = append(opts, service.WithPipelining())
+	}
+	if cfg.Relay.ClaimCheck.Store != "" {
+		store, err := newClaimCheckStore(cfg)
+		if err != nil {
+			log.Fatalf("Error initializing claim-check store: %v", err)
+		}
+		opts = append(opts, service.WithClaimCheck(claimcheck.Config{Store: store, KeyPrefix: cfg.Relay.ClaimCheck.KeyPrefix}))
+	}
+
+	// With leader election only the relay holding the leader lock publishes
+	if cfg.Relay.LeaderElection {
+		elector, err := newLeaderElector(cfg)
+		if err != nil {
+			log.Fatalf("Error initializing leader election: %v", err)
+		}
+		go elector.Run(ctx)
+		opts = append(opts, service.WithLeadership(elector))
+	}
+
+	// Lanes with dedicated workers get their own relay loops, the other lanes share the main loop
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	var sharedLanes []service.Lane
+	for _, lane := range cfg.Relay.Lanes {
+		if lane.Workers == 0 {
+			sharedLanes = append(sharedLanes, priorityLane(lane))
+			continue
+		}
+		laneService := service.NewService(dbRepo, ncRepo, cfg.Relay.BatchSize, append(opts, service.WithPriorityLanes(priorityLane(lane)))...)
+		for i := 0; i < lane.Workers; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				relay(ctx, handler.NewHandler(laneService), cfg)
+			}()
+		}
+	}
+	if len(cfg.Relay.Lanes) > 0 && len(sharedLanes) == 0 {
+		// Every lane has dedicated workers, wait for them to stop
+		return
+	}
+	if len(sharedLanes) > 0 {
+		opts = append(opts, service.WithPriorityLanes(sharedLanes...))
+	}
+	outboxService := service.NewService(dbRepo, ncRepo, cfg.Relay.BatchSize, opts...)
 
 	// Initialize Handler with the service
 	outboxHandler := handler.NewHandler(outboxService)
 
 	// Start processing outbox messages
+	relay(ctx, outboxHandler, cfg)
+	log.Printf("Shutting down the relay")
+}
+
+// relay processes outbox messages until the context is done, sleeping for the poll interval between batches
+func relay(ctx context.Context, outboxHandler handler.Handler, cfg *config.Config) {
 	for {
 		outboxHandler.Process()
 
 		// Sleep before checking again
-		time.Sleep(2 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(cfg.Relay.PollInterval)):
+		}
+	}
+}
+
+// migrate runs the embedded outbox migrations against the configured database
+func migrate(args []string) {
+	cfg, err := config.Parse(args)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	table, version, err := migrateDatabase(cfg)
+	if err != nil {
+		log.Fatalf("Error migrating outbox schema: %v", err)
+	}
+	log.Printf("Outbox table %s is at schema version %d", table, version)
+}
+
+// migrateDatabase migrates the outbox table of the configured database and returns its schema version
+func migrateDatabase(cfg *config.Config) (fmt.Stringer, int, error) {
+	switch cfg.Database.Driver {
+	case config.DriverMySQL:
+		dbConfig := cfg.MySQLConfig()
+		gormDB, err := mysql.Open(dbConfig)
+		if err == nil {
+			err = mysql.Migrate(gormDB, dbConfig.Table())
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		version, err := mysql.SchemaVersion(gormDB, dbConfig.Table())
+		return dbConfig.Table(), version, err
+	case config.DriverSQLite:
+		dbConfig := cfg.SQLiteConfig()
+		gormDB, err := sqlite.Open(dbConfig)
+		if err == nil {
+			err = sqlite.Migrate(gormDB, dbConfig.Table())
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		version, err := sqlite.SchemaVersion(gormDB, dbConfig.Table())
+		return dbConfig.Table(), version, err
+	default:
+		dbConfig := cfg.PostgresConfig()
+		gormDB, err := db.Open(dbConfig)
+		if err == nil {
+			err = db.Migrate(gormDB, dbConfig.Table())
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		version, err := db.SchemaVersion(gormDB, dbConfig.Table())
+		return dbConfig.Table(), version, err
+	}
+}
+
+// newRepository creates the outbox repository of the configured database driver
+func newRepository(cfg *config.Config) (db.Repository, error) {
+	switch cfg.Database.Driver {
+	case config.DriverMySQL:
+		return mysql.NewGormRepository(cfg.MySQLConfig())
+	case config.DriverSQLite:
+		return sqlite.NewGormRepository(cfg.SQLiteConfig())
+	default:
+		return db.NewGormRepository(cfg.PostgresConfig())
+	}
+}
+
+// reencrypt rewraps all messages encrypted with a retired key using the current key
+func reencrypt(args []string) {
+	cfg, err := config.Parse(args)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	encryptor, err := cfg.Encryptor()
+	if err != nil {
+		log.Fatalf("Error initializing encryption: %v", err)
+	}
+	if encryptor == nil {
+		log.Fatalf("Error: no encryption keys configured")
+	}
+
+	dbRepo, err := newRepository(cfg)
+	if err != nil {
+		log.Fatalf("Error initializing DB: %v", err)
+	}
+
+	count, err := service.Reencrypt(dbRepo, encryptor, cfg.Relay.BatchSize)
+	if err != nil {
+		log.Fatalf("Error re-encrypting messages after %d messages: %v", count, err)
+	}
+	log.Printf("Re-encrypted %d messages", count)
+}
+
+// stats prints the message counts by tenant and status, only for the tenant given with -tenant if any
+func stats(args []string) {
+	cfg, err := config.Parse(args)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	dbRepo, err := newRepository(cfg)
+	if err != nil {
+		log.Fatalf("Error initializing DB: %v", err)
+	}
+
+	stats, err := dbRepo.MessageStats(outbox.Query{TenantID: cfg.Stats.Tenant})
+	if err != nil {
+		log.Fatalf("Error querying message stats: %v", err)
+	}
+	fmt.Printf("%-24s %-12s %10s  %s\n", "TENANT", "STATUS", "COUNT", "OLDEST")
+	for _, row := range stats {
+		tenant := row.TenantID
+		if tenant == "" {
+			tenant = "(none)"
+		}
+		fmt.Printf("%-24s %-12s %10d  %s\n", tenant, row.Status, row.Count, row.OldestCreatedAt.Format(time.RFC3339))
+	}
+}
+
+// retryPolicy returns the configured retry settings as a service.RetryPolicy
+func retryPolicy(cfg *config.Config) service.RetryPolicy {
+	return service.RetryPolicy{
+		MaxAttempts:    cfg.Relay.Retry.MaxAttempts,
+		InitialBackoff: time.Duration(cfg.Relay.Retry.InitialBackoff),
+		MaxBackoff:     time.Duration(cfg.Relay.Retry.MaxBackoff),
+	}
+}
+
+// priorityLane returns a configured lane as a service.Lane
+func priorityLane(lane config.LaneConfig) service.Lane {
+	return service.Lane{Name: lane.Name, MinPriority: lane.MinPriority, MaxPriority: lane.MaxPriority, Weight: lane.LaneWeight()}
+}
+
+// newLeaderElector creates the advisory lock leader election on its own database connection
+func newLeaderElector(cfg *config.Config) (db.LeaderElector, error) {
+	dbConfig := cfg.PostgresConfig()
+	sqlDB, err := openSQL(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewLeaderElector(&db.ElectionConfig{
+		DB:            sqlDB,
+		Table:         dbConfig.Table(),
+		CheckInterval: time.Duration(cfg.Relay.LeaderCheckInterval),
+		OnElected:     func() { log.Printf("Elected leader, publishing outbox messages") },
+		OnRevoked:     func() { log.Printf("Lost the leadership, pausing publishing") },
+	})
+}
+
+// newPartitionCoordinator creates the partition leases shared by the sharded relays
+func newPartitionCoordinator(cfg *config.Config) (db.PartitionCoordinator, error) {
+	dbConfig := cfg.PostgresConfig()
+	sqlDB, err := openSQL(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewPartitionCoordinator(&db.ShardingConfig{
+		DB:         sqlDB,
+		Table:      dbConfig.Table(),
+		Partitions: cfg.Relay.Partitions,
+		InstanceID: cfg.InstanceID(),
+		LeaseTTL:   time.Duration(cfg.Relay.PartitionLeaseTTL),
+	})
+}
+
+// openSQL opens a database/sql handle to the configured database
+func openSQL(dbConfig *db.Config) (*sql.DB, error) {
+	gormDB, err := db.Open(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	return gormDB.DB()
+}
+
+// newClaimCheckStore creates the configured blob store for oversized payloads
+func newClaimCheckStore(cfg *config.Config) (claimcheck.BlobStore, error) {
+	if cfg.Relay.ClaimCheck.Store == "file" {
+		return claimcheck.NewFileStore(cfg.Relay.ClaimCheck.Dir)
+	}
+
+	nc, err := natsgo.Connect(cfg.NATS.URL)
+	if err != nil {
+		return nil, err
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
 	}
+	return claimcheck.NewObjectStore(js, cfg.Relay.ClaimCheck.Bucket)
 }
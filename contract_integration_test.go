@@ -0,0 +1,132 @@
+//go:build integration
+// +build integration
+
+package go_transactional_outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/db/mysql"
+	repo "github.com/outbox-go-sdk/internal/db/postgres"
+	domain "github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/outboxtest"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const (
+	mysqlHost = "mysql"
+	mysqlPort = 3306
+	mysqlUser = "outbox"
+	mysqlPass = "rootpassword"
+	mysqlDB   = "transactional_outbox"
+)
+
+// contractTable returns a table name unique to the test, so every contract test starts from an empty outbox
+func contractTable() string {
+	return fmt.Sprintf("contract_%d", time.Now().UnixNano())
+}
+
+// dropContractTable removes the outbox table and its migration records once the test is done
+func dropContractTable(t *testing.T, gormDB *gorm.DB, quoted, name string) {
+	t.Cleanup(func() {
+		gormDB.Exec("DROP TABLE IF EXISTS " + quoted)
+		gormDB.Exec("DELETE FROM outbox_schema_migrations WHERE table_name = ?", name)
+	})
+}
+
+func TestPostgresRepositoryContract(t *testing.T) {
+	gormDB, err := setupDB()
+	require.NoError(t, err)
+
+	outboxtest.RunRepositoryContract(t, func(t *testing.T) db.Repository {
+		table := contractTable()
+		dbRepo, err := repo.NewGormRepository(&repo.Config{DBInstance: gormDB, TableName: table})
+		require.NoError(t, err)
+		dropContractTable(t, gormDB, fmt.Sprintf("%q", table), table)
+		return dbRepo
+	})
+}
+
+func TestPostgresSQLRepositoryContract(t *testing.T) {
+	gormDB, err := setupDB()
+	require.NoError(t, err)
+	sqlDB, err := sql.Open("pgx", postgresConnString())
+	require.NoError(t, err)
+	defer sqlDB.Close()
+
+	outboxtest.RunRepositoryContract(t, func(t *testing.T) db.Repository {
+		table := contractTable()
+		dbRepo, err := repo.NewSQLRepository(&repo.SQLConfig{DB: sqlDB, Table: repo.Table{Name: table}})
+		require.NoError(t, err)
+		dropContractTable(t, gormDB, fmt.Sprintf("%q", table), table)
+		return dbRepo
+	})
+}
+
+func TestPostgresPgxRepositoryContract(t *testing.T) {
+	gormDB, err := setupDB()
+	require.NoError(t, err)
+	pool, err := pgxpool.New(context.Background(), postgresConnString())
+	require.NoError(t, err)
+	defer pool.Close()
+
+	outboxtest.RunRepositoryContract(t, func(t *testing.T) db.Repository {
+		table := contractTable()
+		dbRepo, err := repo.NewPgxRepository(&repo.PgxConfig{Pool: pool, Table: repo.Table{Name: table}})
+		require.NoError(t, err)
+		dropContractTable(t, gormDB, fmt.Sprintf("%q", table), table)
+		return dbRepo
+	})
+}
+
+func TestPgxRepository_WithTx(t *testing.T) {
+	gormDB, err := setupDB()
+	require.NoError(t, err)
+	pool, err := pgxpool.New(context.Background(), postgresConnString())
+	require.NoError(t, err)
+	defer pool.Close()
+
+	table := contractTable()
+	dbRepo, err := repo.NewPgxRepository(&repo.PgxConfig{Pool: pool, Table: repo.Table{Name: table}})
+	require.NoError(t, err)
+	dropContractTable(t, gormDB, fmt.Sprintf("%q", table), table)
+
+	// A message enqueued in a transaction the caller rolls back is never published
+	tx, err := pool.Begin(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, dbRepo.WithTx(tx).CreateOutboxMessage(domain.Message{Payload: []byte("rolled back")}))
+	require.NoError(t, tx.Rollback(context.Background()))
+
+	tx, err = pool.Begin(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, dbRepo.WithTx(tx).CreateOutboxMessage(domain.Message{Payload: []byte("committed")}))
+	require.NoError(t, tx.Commit(context.Background()))
+
+	messages, err := dbRepo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, []byte("committed"), messages[0].Payload)
+}
+
+func TestMySQLRepositoryContract(t *testing.T) {
+	config := &mysql.Config{User: mysqlUser, Password: mysqlPass, Host: mysqlHost, Port: mysqlPort, DBName: mysqlDB}
+	gormDB, err := mysql.Open(config)
+	require.NoError(t, err)
+
+	outboxtest.RunRepositoryContract(t, func(t *testing.T) db.Repository {
+		table := contractTable()
+		dbRepo, err := mysql.NewGormRepository(&mysql.Config{DBInstance: gormDB, TableName: table})
+		require.NoError(t, err)
+		dropContractTable(t, gormDB, "`"+table+"`", table)
+		return dbRepo
+	})
+}
@@ -30,14 +30,18 @@ const (
 
 // Setup the real PostgreSQL and NATS server for integration testing
 func setupDB() (*gorm.DB, error) {
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", postgresUser, postgresPass, postgresHost, postgresPort, postgresDB)
-	db, err := gorm.Open(postgres.Open(connStr), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(postgresConnString()), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
+// postgresConnString returns the connection string of the integration PostgreSQL server
+func postgresConnString() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", postgresUser, postgresPass, postgresHost, postgresPort, postgresDB)
+}
+
 func setupNATS() (*nats.Conn, error) {
 	return nats.Connect(natsURL)
 }
@@ -0,0 +1,108 @@
+package claimcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Header carries the blob store key of a payload published as a claim-check reference
+const Header = "Outbox-Claim-Check"
+
+// ContentType is the Content-Type of claim-check references, so consumers unaware of the claim-check
+// do not mistake a reference for the payload
+const ContentType = "application/vnd.outbox.claim-check+json"
+
+// OriginalContentTypeHeader carries the Content-Type of the payload a claim-check reference stands for
+const OriginalContentTypeHeader = "Outbox-Claim-Check-Content-Type"
+
+// ErrNotFound is returned by a BlobStore when no blob exists for a key
+var ErrNotFound = errors.New("claim-check blob not found")
+
+// BlobStore stores payloads too large to be published directly. The relay never deletes the blobs it
+// stores, as it cannot tell when every consumer is done with them: consumers Release them, or the
+// store's own retention, e.g. a TTL on the Object Store bucket, removes them.
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// Config holds the claim-check settings of the relay
+type Config struct {
+	Store BlobStore
+	// KeyPrefix is prepended to blob keys, e.g. to share a store between several outboxes
+	KeyPrefix string
+}
+
+// Reference is published in place of a payload that was moved to the blob store
+type Reference struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+}
+
+// referenceMessage is the JSON body of a claim-check message
+type referenceMessage struct {
+	ClaimCheck Reference `json:"claim_check"`
+}
+
+// NewReference returns the body of the message published in place of the stored payload
+func NewReference(key string, size int) ([]byte, error) {
+	return json.Marshal(referenceMessage{ClaimCheck: Reference{Key: key, Size: size}})
+}
+
+// Resolve returns the payload of a consumed message, fetching it from the store
+// if the message is a claim-check reference
+func Resolve(store BlobStore, data []byte, headers map[string]string) ([]byte, error) {
+	key, ok := headers[Header]
+	if !ok {
+		return data, nil
+	}
+	payload, err := store.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("fetching claim-check payload %q: %w", key, err)
+	}
+	return payload, nil
+}
+
+// ContentTypeOf returns the Content-Type of the payload of a consumed message, which a claim-check
+// reference carries in OriginalContentTypeHeader
+func ContentTypeOf(headers map[string]string) string {
+	if _, ok := headers[Header]; ok {
+		return headers[OriginalContentTypeHeader]
+	}
+	return headers["Content-Type"]
+}
+
+// Release deletes the blob of a consumed claim-check reference once the payload is no longer needed,
+// e.g. after its last consumer processed it. Messages published inline are left alone.
+func Release(store BlobStore, headers map[string]string) error {
+	key, ok := headers[Header]
+	if !ok {
+		return nil
+	}
+	if err := store.Delete(key); err != nil {
+		return fmt.Errorf("deleting claim-check payload %q: %w", key, err)
+	}
+	return nil
+}
+
+// ReleaseMsg deletes the blob of a consumed NATS message, see Release
+func ReleaseMsg(store BlobStore, msg *nats.Msg) error {
+	key := msg.Header.Get(Header)
+	if key == "" {
+		return nil
+	}
+	return Release(store, map[string]string{Header: key})
+}
+
+// ResolveMsg returns the payload of a consumed NATS message, see Resolve
+func ResolveMsg(store BlobStore, msg *nats.Msg) ([]byte, error) {
+	key := msg.Header.Get(Header)
+	if key == "" {
+		return msg.Data, nil
+	}
+	return Resolve(store, msg.Data, map[string]string{Header: key})
+}
@@ -0,0 +1,79 @@
+package claimcheck
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put("order-1", []byte("large payload")))
+	data, err := store.Get("order-1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("large payload"), data)
+
+	require.NoError(t, store.Delete("order-1"))
+	_, err = store.Get("order-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, store.Delete("order-1"))
+}
+
+func TestFileStore_Failure_InvalidKey(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	assert.Error(t, store.Put("../escape", []byte("data")))
+	assert.Error(t, store.Put(".hidden", []byte("data")))
+	_, err = store.Get("a/b")
+	assert.Error(t, err)
+}
+
+func TestResolveMsg(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.Put("order-1", []byte("large payload")))
+
+	reference, err := NewReference("order-1", 13)
+	require.NoError(t, err)
+	msg := nats.NewMsg("outbox")
+	msg.Data = reference
+	msg.Header.Set(Header, "order-1")
+
+	data, err := ResolveMsg(store, msg)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("large payload"), data)
+
+	plain := nats.NewMsg("outbox")
+	plain.Data = []byte("inline")
+	data, err = ResolveMsg(store, plain)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("inline"), data)
+}
+
+func TestReleaseMsg(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.Put("order-1", []byte("large payload")))
+
+	msg := nats.NewMsg("outbox")
+	msg.Header.Set(Header, "order-1")
+	require.NoError(t, ReleaseMsg(store, msg))
+	_, err = store.Get("order-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, ReleaseMsg(store, nats.NewMsg("outbox")))
+}
+
+func TestContentTypeOf(t *testing.T) {
+	assert.Equal(t, "text/plain", ContentTypeOf(map[string]string{
+		"Content-Type":            ContentType,
+		OriginalContentTypeHeader: "text/plain",
+		Header:                    "order-1",
+	}))
+	assert.Equal(t, "application/json", ContentTypeOf(map[string]string{"Content-Type": "application/json"}))
+}
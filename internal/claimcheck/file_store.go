@@ -0,0 +1,78 @@
+package claimcheck
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileStore keeps blobs as files in a directory, e.g. a volume shared with the consumers
+type fileStore struct {
+	dir string
+}
+
+// NewFileStore creates a BlobStore writing to the given directory, creating it if needed
+func NewFileStore(dir string) (BlobStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("claim-check directory must be provided")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+// Put writes the blob atomically, so readers never see a partial payload
+func (s *fileStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fileStore) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *fileStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path maps a key to a file in the store directory, rejecting keys that would escape it
+func (s *fileStore) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("invalid claim-check key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
@@ -0,0 +1,48 @@
+package claimcheck
+
+import (
+	"errors"
+
+	"github.com/nats-io/nats.go"
+)
+
+// objectStore keeps blobs in a NATS JetStream Object Store bucket
+type objectStore struct {
+	obs nats.ObjectStore
+}
+
+// NewObjectStore creates a BlobStore on the given Object Store bucket, creating the bucket if needed
+func NewObjectStore(js nats.JetStreamContext, bucket string) (BlobStore, error) {
+	obs, err := js.ObjectStore(bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrBucketNotFound) {
+		obs, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket:      bucket,
+			Description: "Outbox claim-check payloads",
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &objectStore{obs: obs}, nil
+}
+
+func (s *objectStore) Put(key string, data []byte) error {
+	_, err := s.obs.PutBytes(key, data)
+	return err
+}
+
+func (s *objectStore) Get(key string) ([]byte, error) {
+	data, err := s.obs.GetBytes(key)
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *objectStore) Delete(key string) error {
+	err := s.obs.Delete(key)
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+)
+
+// SpecVersion is the CloudEvents specification version produced by this package
+const SpecVersion = "1.0"
+
+// StructuredContentType is the content type of events published in structured mode
+const StructuredContentType = "application/cloudevents+json"
+
+// Mode selects how an event is mapped onto a NATS message
+type Mode string
+
+const (
+	// ModeBinary keeps the payload as message data and carries the attributes in "ce-" NATS headers
+	ModeBinary Mode = "binary"
+	// ModeStructured publishes the whole event, attributes and data, as one JSON document
+	ModeStructured Mode = "structured"
+)
+
+// DefaultType is used as the event type for messages without an EventType
+const DefaultType = "outbox.message"
+
+// Config holds the settings for publishing messages as CloudEvents
+type Config struct {
+	Mode Mode
+	// Source identifies the producing service, e.g. "https://example.com/orders" or "/orders-service"
+	Source string
+	// Optional type for messages without an EventType, defaults to DefaultType
+	DefaultType string
+}
+
+// Validate validates the provided CloudEvents configuration
+func (c *Config) Validate() error {
+	if c.Mode != ModeBinary && c.Mode != ModeStructured {
+		return fmt.Errorf("cloudevents mode must be %q or %q, got %q", ModeBinary, ModeStructured, c.Mode)
+	}
+	if c.Source == "" {
+		return fmt.Errorf("cloudevents source must be provided")
+	}
+	return nil
+}
+
+// event is the JSON representation of a CloudEvent in structured mode
+type event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// Encode maps an outbox message onto the data and headers of a CloudEvents NATS message.
+// The message headers are kept alongside the CloudEvents attributes.
+func Encode(config Config, message outbox.Message) ([]byte, map[string]string, error) {
+	e := event{
+		SpecVersion:     SpecVersion,
+		ID:              strconv.FormatUint(uint64(message.ID), 10),
+		Source:          config.Source,
+		Type:            message.EventType,
+		DataContentType: message.ContentType,
+	}
+	if e.Type == "" {
+		e.Type = config.DefaultType
+	}
+	if e.Type == "" {
+		e.Type = DefaultType
+	}
+	if !message.CreatedAt.IsZero() {
+		e.Time = message.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	headers := make(map[string]string, len(message.Headers)+6)
+	for key, value := range message.Headers {
+		headers[key] = value
+	}
+
+	switch config.Mode {
+	case ModeBinary:
+		headers["ce-specversion"] = e.SpecVersion
+		headers["ce-id"] = e.ID
+		headers["ce-source"] = e.Source
+		headers["ce-type"] = e.Type
+		if e.Time != "" {
+			headers["ce-time"] = e.Time
+		}
+		if e.DataContentType != "" {
+			headers["Content-Type"] = e.DataContentType
+		}
+		return message.Payload, headers, nil
+
+	case ModeStructured:
+		// JSON data is embedded as is, anything else is base64 encoded
+		if outbox.IsJSONContentType(e.DataContentType) && json.Valid(message.Payload) {
+			e.Data = message.Payload
+		} else if len(message.Payload) > 0 {
+			e.DataBase64 = message.Payload
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, nil, err
+		}
+		headers["Content-Type"] = StructuredContentType
+		return data, headers, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported cloudevents mode %q", config.Mode)
+	}
+}
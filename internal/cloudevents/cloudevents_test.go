@@ -0,0 +1,77 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var createdAt = time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+
+func TestEncode_Binary(t *testing.T) {
+	config := Config{Mode: ModeBinary, Source: "/orders"}
+	message := outbox.Message{
+		ID:          42,
+		Payload:     []byte{0x08, 0x01},
+		ContentType: "application/x-protobuf",
+		EventType:   "com.example.order.created",
+		Headers:     outbox.Headers{"Outbox-Codec": "protobuf"},
+		CreatedAt:   createdAt,
+	}
+
+	data, headers, err := Encode(config, message)
+	require.NoError(t, err)
+	assert.Equal(t, message.Payload, data)
+	assert.Equal(t, map[string]string{
+		"ce-specversion": "1.0",
+		"ce-id":          "42",
+		"ce-source":      "/orders",
+		"ce-type":        "com.example.order.created",
+		"ce-time":        "2025-03-01T12:30:00Z",
+		"Content-Type":   "application/x-protobuf",
+		"Outbox-Codec":   "protobuf",
+	}, headers)
+}
+
+func TestEncode_StructuredJSON(t *testing.T) {
+	config := Config{Mode: ModeStructured, Source: "/orders", DefaultType: "com.example.order"}
+	message := outbox.Message{ID: 7, Payload: []byte(`{"id":"o-1"}`), ContentType: "application/json", CreatedAt: createdAt}
+
+	data, headers, err := Encode(config, message)
+	require.NoError(t, err)
+	assert.Equal(t, StructuredContentType, headers["Content-Type"])
+	assert.JSONEq(t, `{
+		"specversion": "1.0",
+		"id": "7",
+		"source": "/orders",
+		"type": "com.example.order",
+		"time": "2025-03-01T12:30:00Z",
+		"datacontenttype": "application/json",
+		"data": {"id": "o-1"}
+	}`, string(data))
+}
+
+func TestEncode_StructuredBinaryData(t *testing.T) {
+	config := Config{Mode: ModeStructured, Source: "/orders"}
+	message := outbox.Message{ID: 7, Payload: []byte{0xff, 0x00}}
+
+	data, _, err := Encode(config, message)
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "/wA=", decoded["data_base64"])
+	assert.Equal(t, DefaultType, decoded["type"])
+	assert.NotContains(t, decoded, "time")
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&Config{Mode: ModeBinary, Source: "/orders"}).Validate())
+	assert.Error(t, (&Config{Mode: "envelope", Source: "/orders"}).Validate())
+	assert.Error(t, (&Config{Mode: ModeStructured}).Validate())
+}
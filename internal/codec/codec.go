@@ -0,0 +1,83 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Header is the message header recording which codec encoded the payload
+const Header = "Outbox-Codec"
+
+// Codec serializes typed events into message payloads and back
+type Codec interface {
+	// Name identifies the codec in the message headers
+	Name() string
+	// ContentType is stored with the message and forwarded as the Content-Type header
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{}
+)
+
+func init() {
+	Register(JSON)
+	Register(Protobuf)
+}
+
+// Register makes a codec available to Lookup and Decode under its name
+func Register(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[c.Name()] = c
+}
+
+// Lookup returns the registered codec with the given name
+func Lookup(name string) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[name]
+	return c, ok
+}
+
+// Decode deserializes a consumed payload into T using the codec recorded in the headers.
+// T may be a value or a pointer type such as *pb.OrderCreated.
+func Decode[T any](data []byte, headers map[string]string) (T, error) {
+	var event T
+
+	name, ok := headers[Header]
+	if !ok {
+		return event, fmt.Errorf("message has no %s header", Header)
+	}
+	c, ok := Lookup(name)
+	if !ok {
+		return event, fmt.Errorf("unknown codec %q", name)
+	}
+
+	// Allocate the target for pointer types, protobuf messages are only implemented on pointers
+	target := interface{}(&event)
+	if t := reflect.TypeOf(event); t != nil && t.Kind() == reflect.Pointer {
+		event = reflect.New(t.Elem()).Interface().(T)
+		target = event
+	}
+
+	if err := c.Unmarshal(data, target); err != nil {
+		return event, fmt.Errorf("decoding %s payload: %w", name, err)
+	}
+	return event, nil
+}
+
+// DecodeMsg deserializes a consumed NATS message into T, see Decode
+func DecodeMsg[T any](msg *nats.Msg) (T, error) {
+	headers := make(map[string]string, len(msg.Header))
+	for key := range msg.Header {
+		headers[key] = msg.Header.Get(key)
+	}
+	return Decode[T](msg.Data, headers)
+}
@@ -0,0 +1,54 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type orderCreated struct {
+	OrderID string `json:"order_id"`
+	Amount  int    `json:"amount"`
+}
+
+func TestDecode_JSON(t *testing.T) {
+	data, err := JSON.Marshal(orderCreated{OrderID: "o-1", Amount: 42})
+	require.NoError(t, err)
+
+	event, err := Decode[orderCreated](data, map[string]string{Header: "json"})
+	require.NoError(t, err)
+	assert.Equal(t, orderCreated{OrderID: "o-1", Amount: 42}, event)
+
+	pointer, err := Decode[*orderCreated](data, map[string]string{Header: "json"})
+	require.NoError(t, err)
+	assert.Equal(t, "o-1", pointer.OrderID)
+}
+
+func TestDecode_Protobuf(t *testing.T) {
+	data, err := Protobuf.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+
+	msg := nats.NewMsg("outbox")
+	msg.Data = data
+	msg.Header.Set(Header, "protobuf")
+
+	event, err := DecodeMsg[*wrapperspb.StringValue](msg)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", event.GetValue())
+}
+
+func TestProtobuf_Failure_NotProtoMessage(t *testing.T) {
+	_, err := Protobuf.Marshal(orderCreated{})
+	assert.Error(t, err)
+}
+
+func TestDecode_Failure_UnknownCodec(t *testing.T) {
+	_, err := Decode[orderCreated]([]byte("{}"), map[string]string{Header: "avro"})
+	assert.Error(t, err)
+
+	_, err = Decode[orderCreated]([]byte("{}"), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm is a payload compression algorithm. Its value is used as the Content-Encoding.
+type Algorithm string
+
+const (
+	Gzip Algorithm = "gzip"
+	Zstd Algorithm = "zstd"
+)
+
+// Config holds the payload compression settings
+type Config struct {
+	Algorithm Algorithm
+	// Threshold is the payload size in bytes from which payloads are compressed
+	Threshold int
+	// ForwardCompressed publishes compressed payloads as is with a Content-Encoding header,
+	// instead of decompressing them in the relay
+	ForwardCompressed bool
+}
+
+// Validate validates the provided compression configuration
+func (c *Config) Validate() error {
+	if c.Algorithm != Gzip && c.Algorithm != Zstd {
+		return fmt.Errorf("compression algorithm must be %q or %q, got %q", Gzip, Zstd, c.Algorithm)
+	}
+	if c.Threshold < 0 {
+		return fmt.Errorf("compression threshold must not be negative")
+	}
+	return nil
+}
+
+// ShouldCompress reports whether a payload of the given size reaches the threshold
+func (c *Config) ShouldCompress(size int) bool {
+	return size >= c.Threshold
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the shared zstd encoder and decoder, both are safe for concurrent use
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr == nil {
+			zstdDecoder, zstdErr = zstd.NewReader(nil)
+		}
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// Compress compresses data with the algorithm
+func Compress(algorithm Algorithm, data []byte) ([]byte, error) {
+	switch algorithm {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		encoder, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
+
+// Decompress decompresses data compressed with the algorithm
+func Decompress(algorithm Algorithm, data []byte) ([]byte, error) {
+	switch algorithm {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case Zstd:
+		_, decoder, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
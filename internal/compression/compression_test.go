@@ -0,0 +1,40 @@
+package compression
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"sku":"A-1","qty":1},`), 500)
+
+	for _, algorithm := range []Algorithm{Gzip, Zstd} {
+		compressed, err := Compress(algorithm, payload)
+		require.NoError(t, err, algorithm)
+		assert.Less(t, len(compressed), len(payload), algorithm)
+
+		decompressed, err := Decompress(algorithm, compressed)
+		require.NoError(t, err, algorithm)
+		assert.Equal(t, payload, decompressed, algorithm)
+	}
+}
+
+func TestCompress_Failure_UnknownAlgorithm(t *testing.T) {
+	_, err := Compress("brotli", []byte("data"))
+	assert.Error(t, err)
+
+	_, err = Decompress("brotli", []byte("data"))
+	assert.Error(t, err)
+}
+
+func TestConfig(t *testing.T) {
+	config := Config{Algorithm: Zstd, Threshold: 1024}
+	assert.NoError(t, config.Validate())
+	assert.False(t, config.ShouldCompress(1023))
+	assert.True(t, config.ShouldCompress(1024))
+
+	assert.Error(t, (&Config{Algorithm: "lz4"}).Validate())
+}
@@ -0,0 +1,647 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/cloudevents"
+	"github.com/outbox-go-sdk/internal/compression"
+	"github.com/outbox-go-sdk/internal/db/mysql"
+	"github.com/outbox-go-sdk/internal/db/postgres"
+	"github.com/outbox-go-sdk/internal/db/sqlite"
+	"github.com/outbox-go-sdk/internal/encryption"
+	"github.com/outbox-go-sdk/internal/publisher/nats"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the configuration of the relay binary
+type Config struct {
+	Database DatabaseConfig `json:"database" yaml:"database"`
+	NATS     NATSConfig     `json:"nats" yaml:"nats"`
+	Relay    RelayConfig    `json:"relay" yaml:"relay"`
+	// Encryption configures payload decryption in the relay and key rotation
+	Encryption EncryptionConfig `json:"encryption" yaml:"encryption"`
+	// Compression configures payload compression and whether the relay forwards compressed payloads
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+	// Stats configures the stats subcommand
+	Stats StatsConfig `json:"stats" yaml:"stats"`
+}
+
+// StatsConfig holds the settings of the stats subcommand
+type StatsConfig struct {
+	// Tenant only counts the messages of a tenant when set, the empty tenant counting messages without one
+	Tenant *string `json:"tenant" yaml:"tenant"`
+}
+
+// Database drivers the relay can store the outbox with
+const (
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+	DriverSQLite   = "sqlite"
+)
+
+// DatabaseConfig holds the database connection settings
+type DatabaseConfig struct {
+	// Driver selects the database: DriverPostgres, DriverMySQL or DriverSQLite
+	Driver string `json:"driver" yaml:"driver"`
+	// URL is a PostgreSQL connection URL, or a go-sql-driver DSN for MySQL
+	URL      string `json:"url" yaml:"url"`
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+	Name     string `json:"name" yaml:"name"`
+	SSLMode  string `json:"ssl_mode" yaml:"ssl_mode"`
+	// Path is the SQLite database file
+	Path string `json:"path" yaml:"path"`
+	// Schema and TableName locate the outbox table
+	Schema    string `json:"schema" yaml:"schema"`
+	TableName string `json:"table_name" yaml:"table_name"`
+	// StoreJSONAsJSONB stores JSON payloads in a queryable jsonb column
+	StoreJSONAsJSONB bool `json:"store_json_as_jsonb" yaml:"store_json_as_jsonb"`
+	// SkipAutoMigrate only verifies the schema version on startup instead of migrating
+	SkipAutoMigrate bool `json:"skip_auto_migrate" yaml:"skip_auto_migrate"`
+}
+
+// NATSConfig holds the NATS connection settings
+type NATSConfig struct {
+	URL string `json:"url" yaml:"url"`
+	// JetStream confirms pipelined batches with JetStream acks instead of a flush
+	JetStream bool `json:"jetstream" yaml:"jetstream"`
+	// AckTimeout bounds how long a batch waits for its acks or flush
+	AckTimeout Duration `json:"ack_timeout" yaml:"ack_timeout"`
+}
+
+// RelayConfig holds the settings of the outbox processing loop
+type RelayConfig struct {
+	BatchSize    int         `json:"batch_size" yaml:"batch_size"`
+	PollInterval Duration    `json:"poll_interval" yaml:"poll_interval"`
+	Retry        RetryConfig `json:"retry" yaml:"retry"`
+	// Pipeline publishes each batch asynchronously and marks the confirmed messages in one UPDATE
+	Pipeline bool `json:"pipeline" yaml:"pipeline"`
+	// LeaderElection only lets the relay holding the leader lock publish, for strict global ordering
+	LeaderElection bool `json:"leader_election" yaml:"leader_election"`
+	// LeaderCheckInterval is how often followers try to take over and the leader checks its session
+	LeaderCheckInterval Duration `json:"leader_check_interval" yaml:"leader_check_interval"`
+	// Partitions shards the outbox across relay instances by hash of the partition key when set
+	Partitions int `json:"partitions" yaml:"partitions"`
+	// InstanceID identifies the relay instance among the shards, defaults to the host name and process id
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+	// PartitionLeaseTTL is how long a partition stays leased to an instance that stopped renewing it
+	PartitionLeaseTTL Duration `json:"partition_lease_ttl" yaml:"partition_lease_ttl"`
+	// LeaseTTL claims batches with leases of this duration and publishes them outside of a transaction when set
+	LeaseTTL Duration `json:"lease_ttl" yaml:"lease_ttl"`
+	// TenantFairness shares every batch equally among the tenants with due messages
+	TenantFairness bool `json:"tenant_fairness" yaml:"tenant_fairness"`
+	// TenantSubjectPrefix publishes tenant messages to "<prefix><tenant id>.outbox" when set
+	TenantSubjectPrefix string `json:"tenant_subject_prefix" yaml:"tenant_subject_prefix"`
+	// MetricsAddr serves the relay counters under /debug/vars when set, e.g. ":8080"
+	MetricsAddr string `json:"metrics_addr" yaml:"metrics_addr"`
+	// CloudEvents publishes messages as CloudEvents when its mode is set
+	CloudEvents CloudEventsConfig `json:"cloudevents" yaml:"cloudevents"`
+	// ClaimCheck moves payloads exceeding the NATS max payload to a blob store when its store is set
+	ClaimCheck ClaimCheckConfig `json:"claim_check" yaml:"claim_check"`
+	// Lanes serves messages by priority when set, they can only be configured in the config file
+	Lanes []LaneConfig `json:"lanes" yaml:"lanes"`
+}
+
+// LaneConfig holds a priority lane
+type LaneConfig struct {
+	Name        string `json:"name" yaml:"name"`
+	MinPriority int    `json:"min_priority" yaml:"min_priority"`
+	MaxPriority int    `json:"max_priority" yaml:"max_priority"`
+	Weight      int    `json:"weight" yaml:"weight"`
+	// Workers runs a dedicated pool of relay workers for the lane, 0 shares the main relay loop
+	Workers int `json:"workers" yaml:"workers"`
+}
+
+// RetryConfig holds the retry policy used when publishing fails
+type RetryConfig struct {
+	MaxAttempts    int      `json:"max_attempts" yaml:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff" yaml:"max_backoff"`
+}
+
+// CloudEventsConfig holds the CloudEvents envelope settings
+type CloudEventsConfig struct {
+	// Mode is "binary" or "structured", empty disables the envelope
+	Mode        string `json:"mode" yaml:"mode"`
+	Source      string `json:"source" yaml:"source"`
+	DefaultType string `json:"default_type" yaml:"default_type"`
+}
+
+// ClaimCheckConfig holds the claim-check blob store settings
+type ClaimCheckConfig struct {
+	// Store is "file" or "nats", empty disables the claim-check
+	Store string `json:"store" yaml:"store"`
+	// Dir is the directory of the "file" store
+	Dir string `json:"dir" yaml:"dir"`
+	// Bucket is the Object Store bucket of the "nats" store
+	Bucket    string `json:"bucket" yaml:"bucket"`
+	KeyPrefix string `json:"key_prefix" yaml:"key_prefix"`
+}
+
+// EncryptionConfig holds the key-encryption keys for payload encryption
+type EncryptionConfig struct {
+	// CurrentKeyID names the key new messages are encrypted with and old ones are rotated to
+	CurrentKeyID string `json:"current_key_id" yaml:"current_key_id"`
+	// Keys is the key set as "id1:base64key1,id2:base64key2"
+	Keys string `json:"keys" yaml:"keys"`
+}
+
+// CompressionConfig holds the payload compression settings
+type CompressionConfig struct {
+	// Algorithm is "gzip" or "zstd", empty disables compression
+	Algorithm         string `json:"algorithm" yaml:"algorithm"`
+	Threshold         int    `json:"threshold" yaml:"threshold"`
+	ForwardCompressed bool   `json:"forward_compressed" yaml:"forward_compressed"`
+}
+
+// Duration is a time.Duration that can be read from strings such as "2s" in config files
+type Duration time.Duration
+
+// UnmarshalText parses a duration string such as "500ms" or "2s"
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText formats the duration as a string such as "2s"
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Default returns the configuration used when nothing else is provided
+func Default() *Config {
+	return &Config{
+		Database: DatabaseConfig{
+			Driver:  DriverPostgres,
+			SSLMode: "disable",
+		},
+		Relay: RelayConfig{
+			BatchSize:    100,
+			PollInterval: Duration(2 * time.Second),
+			Retry: RetryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: Duration(100 * time.Millisecond),
+				MaxBackoff:     Duration(2 * time.Second),
+			},
+		},
+	}
+}
+
+// Load builds the configuration with Parse and validates it
+func Load(args []string) (*Config, error) {
+	cfg, err := Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse builds the configuration from, in increasing order of precedence, the defaults,
+// an optional YAML/JSON config file, environment variables and command line flags.
+// The config file is taken from the -config flag or the OUTBOX_CONFIG_FILE variable.
+// The result is not validated, so callers needing only part of it can check that part.
+func Parse(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("outbox-relay", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("OUTBOX_CONFIG_FILE"), "path to a YAML or JSON config file")
+	flagValues := make(map[string]*rawValue, len(bindings))
+	for _, b := range bindings {
+		value := &rawValue{boolFlag: b.boolFlag}
+		flagValues[b.flag] = value
+		fs.Var(value, b.flag, fmt.Sprintf("%s (env %s)", b.usage, b.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range bindings {
+		value, ok := os.LookupEnv(b.env)
+		if !ok {
+			continue
+		}
+		if err := b.set(cfg, value); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", b.env, err)
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		value, ok := flagValues[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, b := range bindings {
+			if b.flag == f.Name {
+				if err := b.set(cfg, value.value); err != nil {
+					flagErr = fmt.Errorf("invalid value for -%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+	return cfg, nil
+}
+
+// loadFile merges the settings from a YAML or JSON file into the configuration
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".json":
+		err = json.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file extension %q, expected .yaml, .yml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate validates the loaded configuration
+func (c *Config) Validate() error {
+	switch c.Database.Driver {
+	case DriverPostgres:
+		if err := c.PostgresConfig().Validate(); err != nil {
+			return err
+		}
+	case DriverMySQL, DriverSQLite:
+		if err := c.validateOtherDriver(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported database driver %q, expected %s, %s or %s", c.Database.Driver, DriverPostgres, DriverMySQL, DriverSQLite)
+	}
+	if err := c.NATSConfig().Validate(); err != nil {
+		return err
+	}
+	if c.Relay.BatchSize <= 0 {
+		return fmt.Errorf("relay batch size must be positive, got %d", c.Relay.BatchSize)
+	}
+	if c.Relay.PollInterval <= 0 {
+		return fmt.Errorf("relay poll interval must be positive, got %s", time.Duration(c.Relay.PollInterval))
+	}
+	if c.Relay.Partitions < 0 || c.Relay.PartitionLeaseTTL < 0 {
+		return fmt.Errorf("relay partitions and partition lease TTL must not be negative")
+	}
+	if c.Relay.LeaseTTL < 0 {
+		return fmt.Errorf("lease TTL must not be negative")
+	}
+	// A sharded relay keeps the messages of a partition key in order only by publishing a batch
+	// and marking it processed before it claims the next one
+	if c.Relay.Partitions > 0 && (c.Relay.Pipeline || c.Relay.LeaseTTL > 0) {
+		return fmt.Errorf("relay partitions cannot be combined with pipelining or a lease TTL")
+	}
+	// The leader publishes serially, holding the lock it verifies before each batch
+	if c.Relay.LeaderElection && (c.Relay.Pipeline || c.Relay.LeaseTTL > 0) {
+		return fmt.Errorf("leader election cannot be combined with pipelining or a lease TTL")
+	}
+	if c.Relay.LeaderCheckInterval < 0 {
+		return fmt.Errorf("leader check interval must not be negative")
+	}
+	if c.Relay.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("retry max attempts must be at least 1, got %d", c.Relay.Retry.MaxAttempts)
+	}
+	if c.Relay.Retry.InitialBackoff < 0 || c.Relay.Retry.MaxBackoff < 0 {
+		return fmt.Errorf("retry backoffs must not be negative")
+	}
+	if c.Relay.Retry.MaxBackoff > 0 && c.Relay.Retry.MaxBackoff < c.Relay.Retry.InitialBackoff {
+		return fmt.Errorf("retry max backoff must not be lower than the initial backoff")
+	}
+	if _, err := c.Encryptor(); err != nil {
+		return err
+	}
+	if ce := c.CloudEventsConfig(); ce != nil {
+		if err := ce.Validate(); err != nil {
+			return err
+		}
+	}
+	switch cc := c.Relay.ClaimCheck; cc.Store {
+	case "":
+	case "file":
+		if cc.Dir == "" {
+			return fmt.Errorf("claim-check dir must be provided for the file store")
+		}
+	case "nats":
+		if cc.Bucket == "" {
+			return fmt.Errorf("claim-check bucket must be provided for the nats store")
+		}
+	default:
+		return fmt.Errorf("claim-check store must be \"file\" or \"nats\", got %q", cc.Store)
+	}
+	if cc := c.CompressionConfig(); cc != nil {
+		if err := cc.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(c.Relay.Lanes) > 0 {
+		for _, lane := range c.Relay.Lanes {
+			if lane.Workers < 0 {
+				return fmt.Errorf("lane %q: workers must not be negative", lane.Name)
+			}
+			if lane.Workers > 0 && c.Relay.Partitions > 0 {
+				return fmt.Errorf("lane %q: workers cannot be combined with relay partitions", lane.Name)
+			}
+			if lane.Workers > 1 && c.Relay.LeaderElection {
+				return fmt.Errorf("lane %q: more than one worker cannot be combined with leader election", lane.Name)
+			}
+		}
+		if err := validateLanes(c.Relay.Lanes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateLanes checks that the lanes have valid, non-overlapping priority ranges
+func validateLanes(lanes []LaneConfig) error {
+	for i, lane := range lanes {
+		if lane.MinPriority > lane.MaxPriority {
+			return fmt.Errorf("lane %q: min priority %d is above max priority %d", lane.Name, lane.MinPriority, lane.MaxPriority)
+		}
+		if lane.Weight < 0 {
+			return fmt.Errorf("lane %q: weight must not be negative", lane.Name)
+		}
+		for _, other := range lanes[:i] {
+			if lane.MinPriority <= other.MaxPriority && other.MinPriority <= lane.MaxPriority {
+				return fmt.Errorf("lane %q overlaps lane %q", lane.Name, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateOtherDriver validates the MySQL or SQLite settings, which have no leader election or partition leases
+func (c *Config) validateOtherDriver() error {
+	if c.Relay.LeaderElection || c.Relay.Partitions > 0 {
+		return fmt.Errorf("leader election and relay partitions require the %s driver", DriverPostgres)
+	}
+	if c.Database.Driver == DriverMySQL {
+		return c.MySQLConfig().Validate()
+	}
+	return c.SQLiteConfig().Validate()
+}
+
+// port returns the configured database port, or the driver's default one
+func (d DatabaseConfig) port(defaultPort int) int {
+	if d.Port == 0 {
+		return defaultPort
+	}
+	return d.Port
+}
+
+// PostgresConfig returns the database settings as a postgres.Config
+func (c *Config) PostgresConfig() *postgres.Config {
+	return &postgres.Config{
+		URL:      c.Database.URL,
+		User:     c.Database.User,
+		Password: c.Database.Password,
+		Host:     c.Database.Host,
+		Port:     c.Database.port(5432),
+		DBName:   c.Database.Name,
+		SSLMode:  c.Database.SSLMode,
+
+		Schema:           c.Database.Schema,
+		TableName:        c.Database.TableName,
+		StoreJSONAsJSONB: c.Database.StoreJSONAsJSONB,
+		SkipAutoMigrate:  c.Database.SkipAutoMigrate,
+	}
+}
+
+// MySQLConfig returns the database settings as a mysql.Config
+func (c *Config) MySQLConfig() *mysql.Config {
+	return &mysql.Config{
+		DSN:      c.Database.URL,
+		User:     c.Database.User,
+		Password: c.Database.Password,
+		Host:     c.Database.Host,
+		Port:     c.Database.port(3306),
+		DBName:   c.Database.Name,
+
+		Schema:          c.Database.Schema,
+		TableName:       c.Database.TableName,
+		SkipAutoMigrate: c.Database.SkipAutoMigrate,
+	}
+}
+
+// SQLiteConfig returns the database settings as a sqlite.Config
+func (c *Config) SQLiteConfig() *sqlite.Config {
+	return &sqlite.Config{
+		Path:            c.Database.Path,
+		TableName:       c.Database.TableName,
+		SkipAutoMigrate: c.Database.SkipAutoMigrate,
+	}
+}
+
+// NATSConfig returns the NATS settings as a nats.Config
+func (c *Config) NATSConfig() *nats.Config {
+	return &nats.Config{
+		URL:        c.NATS.URL,
+		JetStream:  c.NATS.JetStream,
+		AckTimeout: time.Duration(c.NATS.AckTimeout),
+	}
+}
+
+// InstanceID returns the id of the relay instance, defaulting to its host name and process id
+func (c *Config) InstanceID() string {
+	if c.Relay.InstanceID != "" {
+		return c.Relay.InstanceID
+	}
+	return postgres.DefaultInstanceID()
+}
+
+// LaneWeight returns the weight of the lane, defaulting to 1
+func (l LaneConfig) LaneWeight() int {
+	if l.Weight == 0 {
+		return 1
+	}
+	return l.Weight
+}
+
+// CloudEventsConfig returns the CloudEvents settings, or nil when the envelope is disabled
+func (c *Config) CloudEventsConfig() *cloudevents.Config {
+	if c.Relay.CloudEvents.Mode == "" {
+		return nil
+	}
+	return &cloudevents.Config{
+		Mode:        cloudevents.Mode(c.Relay.CloudEvents.Mode),
+		Source:      c.Relay.CloudEvents.Source,
+		DefaultType: c.Relay.CloudEvents.DefaultType,
+	}
+}
+
+// CompressionConfig returns the compression settings, or nil when compression is disabled
+func (c *Config) CompressionConfig() *compression.Config {
+	if c.Compression.Algorithm == "" {
+		return nil
+	}
+	return &compression.Config{
+		Algorithm:         compression.Algorithm(c.Compression.Algorithm),
+		Threshold:         c.Compression.Threshold,
+		ForwardCompressed: c.Compression.ForwardCompressed,
+	}
+}
+
+// Encryptor returns the payload encryptor, or nil when no encryption keys are configured
+func (c *Config) Encryptor() (*encryption.Encryptor, error) {
+	if c.Encryption.CurrentKeyID == "" && c.Encryption.Keys == "" {
+		return nil, nil
+	}
+	keys, err := encryption.ParseKeys(c.Encryption.Keys)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := encryption.NewStaticKeyProvider(c.Encryption.CurrentKeyID, keys)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewEncryptor(provider), nil
+}
+
+// binding ties a setting to its command line flag and environment variable
+type binding struct {
+	flag     string
+	env      string
+	usage    string
+	set      func(c *Config, value string) error
+	boolFlag bool
+}
+
+var bindings = []binding{
+	{"db-driver", "OUTBOX_DB_DRIVER", "database driver: postgres, mysql or sqlite", setString(func(c *Config) *string { return &c.Database.Driver }), false},
+	{"database-url", "DATABASE_URL", "full PostgreSQL connection URL or MySQL DSN", setString(func(c *Config) *string { return &c.Database.URL }), false},
+	{"db-user", "OUTBOX_DB_USER", "database user", setString(func(c *Config) *string { return &c.Database.User }), false},
+	{"db-password", "OUTBOX_DB_PASSWORD", "database password", setString(func(c *Config) *string { return &c.Database.Password }), false},
+	{"db-host", "OUTBOX_DB_HOST", "database host", setString(func(c *Config) *string { return &c.Database.Host }), false},
+	{"db-port", "OUTBOX_DB_PORT", "database port, 5432 for PostgreSQL and 3306 for MySQL by default", setInt(func(c *Config) *int { return &c.Database.Port }), false},
+	{"db-name", "OUTBOX_DB_NAME", "database name", setString(func(c *Config) *string { return &c.Database.Name }), false},
+	{"db-sslmode", "OUTBOX_DB_SSLMODE", "PostgreSQL sslmode", setString(func(c *Config) *string { return &c.Database.SSLMode }), false},
+	{"db-path", "OUTBOX_DB_PATH", "SQLite database file", setString(func(c *Config) *string { return &c.Database.Path }), false},
+	{"db-schema", "OUTBOX_DB_SCHEMA", "schema holding the outbox table", setString(func(c *Config) *string { return &c.Database.Schema }), false},
+	{"table-name", "OUTBOX_TABLE_NAME", "name of the outbox table", setString(func(c *Config) *string { return &c.Database.TableName }), false},
+	{"store-json-as-jsonb", "OUTBOX_STORE_JSON_AS_JSONB", "store JSON payloads in a jsonb column", setBool(func(c *Config) *bool { return &c.Database.StoreJSONAsJSONB }), true},
+	{"db-skip-auto-migrate", "OUTBOX_DB_SKIP_AUTO_MIGRATE", "only verify the schema version on startup", setBool(func(c *Config) *bool { return &c.Database.SkipAutoMigrate }), true},
+	{"nats-url", "NATS_URL", "NATS server URL", setString(func(c *Config) *string { return &c.NATS.URL }), false},
+	{"nats-jetstream", "NATS_JETSTREAM", "confirm pipelined batches with JetStream acks", setBool(func(c *Config) *bool { return &c.NATS.JetStream }), true},
+	{"nats-ack-timeout", "NATS_ACK_TIMEOUT", "time a batch waits for its acks or flush", setDuration(func(c *Config) *Duration { return &c.NATS.AckTimeout }), false},
+	{"pipeline", "OUTBOX_PIPELINE", "publish batches asynchronously and mark them processed at once", setBool(func(c *Config) *bool { return &c.Relay.Pipeline }), true},
+	{"leader-election", "OUTBOX_LEADER_ELECTION", "only publish from the relay holding the leader lock", setBool(func(c *Config) *bool { return &c.Relay.LeaderElection }), true},
+	{"leader-check-interval", "OUTBOX_LEADER_CHECK_INTERVAL", "how often the leader lock is tried or checked", setDuration(func(c *Config) *Duration { return &c.Relay.LeaderCheckInterval }), false},
+	{"partitions", "OUTBOX_PARTITIONS", "number of partitions sharding the outbox across relays", setInt(func(c *Config) *int { return &c.Relay.Partitions }), false},
+	{"instance-id", "OUTBOX_INSTANCE_ID", "id of this relay instance among the shards", setString(func(c *Config) *string { return &c.Relay.InstanceID }), false},
+	{"partition-lease-ttl", "OUTBOX_PARTITION_LEASE_TTL", "how long a partition lease lasts without renewal", setDuration(func(c *Config) *Duration { return &c.Relay.PartitionLeaseTTL }), false},
+	{"lease-ttl", "OUTBOX_LEASE_TTL", "lease claimed batches for this long and publish them outside of a transaction", setDuration(func(c *Config) *Duration { return &c.Relay.LeaseTTL }), false},
+	{"tenant-fairness", "OUTBOX_TENANT_FAIRNESS", "share every batch equally among tenants", setBool(func(c *Config) *bool { return &c.Relay.TenantFairness }), true},
+	{"tenant-subject-prefix", "OUTBOX_TENANT_SUBJECT_PREFIX", "publish tenant messages to <prefix><tenant>.outbox", setString(func(c *Config) *string { return &c.Relay.TenantSubjectPrefix }), false},
+	{"batch-size", "OUTBOX_BATCH_SIZE", "number of messages processed per poll", setInt(func(c *Config) *int { return &c.Relay.BatchSize }), false},
+	{"poll-interval", "OUTBOX_POLL_INTERVAL", "delay between two polls", setDuration(func(c *Config) *Duration { return &c.Relay.PollInterval }), false},
+	{"retry-max-attempts", "OUTBOX_RETRY_MAX_ATTEMPTS", "publish attempts per message", setInt(func(c *Config) *int { return &c.Relay.Retry.MaxAttempts }), false},
+	{"retry-initial-backoff", "OUTBOX_RETRY_INITIAL_BACKOFF", "delay before the first retry", setDuration(func(c *Config) *Duration { return &c.Relay.Retry.InitialBackoff }), false},
+	{"retry-max-backoff", "OUTBOX_RETRY_MAX_BACKOFF", "maximum delay between retries", setDuration(func(c *Config) *Duration { return &c.Relay.Retry.MaxBackoff }), false},
+	{"encryption-key-id", "OUTBOX_ENCRYPTION_KEY_ID", "id of the current payload encryption key", setString(func(c *Config) *string { return &c.Encryption.CurrentKeyID }), false},
+	{"encryption-keys", "OUTBOX_ENCRYPTION_KEYS", "payload encryption keys as id:base64key,...", setString(func(c *Config) *string { return &c.Encryption.Keys }), false},
+	{"metrics-addr", "OUTBOX_METRICS_ADDR", "address serving metrics under /debug/vars", setString(func(c *Config) *string { return &c.Relay.MetricsAddr }), false},
+	{"claim-check-store", "OUTBOX_CLAIM_CHECK_STORE", "blob store for oversized payloads, \"file\" or \"nats\"", setString(func(c *Config) *string { return &c.Relay.ClaimCheck.Store }), false},
+	{"claim-check-dir", "OUTBOX_CLAIM_CHECK_DIR", "directory of the file claim-check store", setString(func(c *Config) *string { return &c.Relay.ClaimCheck.Dir }), false},
+	{"claim-check-bucket", "OUTBOX_CLAIM_CHECK_BUCKET", "Object Store bucket of the nats claim-check store", setString(func(c *Config) *string { return &c.Relay.ClaimCheck.Bucket }), false},
+	{"claim-check-key-prefix", "OUTBOX_CLAIM_CHECK_KEY_PREFIX", "prefix of claim-check blob keys", setString(func(c *Config) *string { return &c.Relay.ClaimCheck.KeyPrefix }), false},
+	{"compression", "OUTBOX_COMPRESSION", "payload compression, \"gzip\" or \"zstd\"", setString(func(c *Config) *string { return &c.Compression.Algorithm }), false},
+	{"compression-threshold", "OUTBOX_COMPRESSION_THRESHOLD", "payload size in bytes from which payloads are compressed", setInt(func(c *Config) *int { return &c.Compression.Threshold }), false},
+	{"forward-compressed", "OUTBOX_FORWARD_COMPRESSED", "publish compressed payloads with a Content-Encoding header", setBool(func(c *Config) *bool { return &c.Compression.ForwardCompressed }), true},
+	{"tenant", "OUTBOX_STATS_TENANT", "only count the messages of this tenant in stats", setOptionalString(func(c *Config) **string { return &c.Stats.Tenant }), false},
+	{"cloudevents-mode", "OUTBOX_CLOUDEVENTS_MODE", "publish CloudEvents in \"binary\" or \"structured\" mode", setString(func(c *Config) *string { return &c.Relay.CloudEvents.Mode }), false},
+	{"cloudevents-source", "OUTBOX_CLOUDEVENTS_SOURCE", "CloudEvents source attribute", setString(func(c *Config) *string { return &c.Relay.CloudEvents.Source }), false},
+	{"cloudevents-default-type", "OUTBOX_CLOUDEVENTS_DEFAULT_TYPE", "CloudEvents type for messages without an event type", setString(func(c *Config) *string { return &c.Relay.CloudEvents.DefaultType }), false},
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setOptionalString(field func(*Config) **string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = &value
+		return nil
+	}
+}
+
+func setInt(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = parsed
+		return nil
+	}
+}
+
+func setBool(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = parsed
+		return nil
+	}
+}
+
+func setDuration(field func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		return field(c).UnmarshalText([]byte(value))
+	}
+}
+
+// rawValue records a flag value as given so it can be applied after the file and environment
+type rawValue struct {
+	value    string
+	boolFlag bool
+}
+
+func (v *rawValue) String() string {
+	return v.value
+}
+
+func (v *rawValue) Set(value string) error {
+	v.value = value
+	return nil
+}
+
+func (v *rawValue) IsBoolFlag() bool {
+	return v.boolFlag
+}
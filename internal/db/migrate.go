@@ -0,0 +1,164 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gorm.io/gorm"
+)
+
+// MigrationsTable records which migrations have been applied to which outbox table.
+// It lives in the schema of the outbox table.
+const MigrationsTable = "outbox_schema_migrations"
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	sql     *template.Template
+}
+
+// Render returns the migration SQL, executing its template with the backend's data for the outbox table
+func (m Migration) Render(data any) (string, error) {
+	var sql bytes.Buffer
+	if err := m.sql.Execute(&sql, data); err != nil {
+		return "", fmt.Errorf("rendering migration %s: %w", m.Name, err)
+	}
+	return sql.String(), nil
+}
+
+// Dialect adapts the migration runner to a database
+type Dialect struct {
+	// Quote is the identifier quote, e.g. `"` or "`"
+	Quote string
+	// Serialize runs fn on a single connection while holding a lock on key, so that concurrent
+	// migration runs of the same outbox table apply each migration once
+	Serialize func(db *gorm.DB, key string, fn func(conn *gorm.DB) error) error
+	// CreateSchema formats the statement creating a missing schema from its quoted name, empty when
+	// the database has no schemas
+	CreateSchema string
+	// MigrationsColumns declares the table_name, version and applied_at columns of the migrations table
+	MigrationsColumns string
+	// TableExists reports whether a table exists in the schema, or in the connection's one when empty
+	TableExists func(db *gorm.DB, schema, name string) (bool, error)
+}
+
+// LoadMigrations reads the migrations of the migrations directory, named <version>_<description>.sql,
+// ordered by version
+func LoadMigrations(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		prefix, _, found := strings.Cut(entry.Name(), "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s must be named <version>_<description>.sql", entry.Name())
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(files, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sql, err := template.New(entry.Name()).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("parsing migration %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: entry.Name(), sql: sql})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// LatestVersion returns the version of the newest migration, or 0 if there are none
+func LatestVersion(migrations []Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies the migrations newer than the schema version of the outbox table, rendered with data
+func Migrate(gormDB *gorm.DB, dialect Dialect, schema, table string, migrations []Migration, data any) error {
+	migrationsTable := QuoteName(dialect.Quote, schema, MigrationsTable)
+	return dialect.Serialize(gormDB, migrationsTable, func(conn *gorm.DB) error {
+		if schema != "" && dialect.CreateSchema != "" {
+			if err := conn.Exec(fmt.Sprintf(dialect.CreateSchema, QuoteName(dialect.Quote, "", schema))).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s, PRIMARY KEY (table_name, version))",
+			migrationsTable, dialect.MigrationsColumns)).Error; err != nil {
+			return err
+		}
+
+		current, err := appliedVersion(conn, migrationsTable, table)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.Version <= current {
+				continue
+			}
+			sql, err := m.Render(data)
+			if err != nil {
+				return err
+			}
+			if err := conn.Exec(sql).Error; err != nil {
+				return fmt.Errorf("applying migration %s: %w", m.Name, err)
+			}
+			if err := conn.Exec(fmt.Sprintf("INSERT INTO %s (table_name, version) VALUES (?, ?)", migrationsTable), table, m.Version).Error; err != nil {
+				return fmt.Errorf("recording migration %s: %w", m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// SchemaVersion returns the version of the last migration applied to the outbox table, or 0 if none
+func SchemaVersion(gormDB *gorm.DB, dialect Dialect, schema, table string) (int, error) {
+	exists, err := dialect.TableExists(gormDB, schema, MigrationsTable)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return appliedVersion(gormDB, QuoteName(dialect.Quote, schema, MigrationsTable), table)
+}
+
+// VerifySchema checks that the outbox table is at least at the required schema version.
+// A newer schema is accepted, so relays can keep running while a newer release rolls out:
+// migrations only add to the schema.
+func VerifySchema(gormDB *gorm.DB, dialect Dialect, schema, table string, required int) error {
+	version, err := SchemaVersion(gormDB, dialect, schema, table)
+	if err != nil {
+		return err
+	}
+	if version < required {
+		name := table
+		if schema != "" {
+			name = schema + "." + table
+		}
+		return fmt.Errorf("outbox table %s is at schema version %d, expected at least %d: run the outbox migrations", name, version, required)
+	}
+	return nil
+}
+
+// appliedVersion returns the highest migration version recorded for the outbox table
+func appliedVersion(gormDB *gorm.DB, migrationsTable, table string) (int, error) {
+	var version int
+	err := gormDB.Raw(fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s WHERE table_name = ?", migrationsTable), table).Scan(&version).Error
+	return version, err
+}
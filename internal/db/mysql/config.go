@@ -0,0 +1,90 @@
+package mysql
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// utcTimeZone is the session time zone the repository works in, so that NOW() and the
+// times written by the driver agree
+const utcTimeZone = "'+00:00'"
+
+// Config holds the configuration for the MySQL connection
+type Config struct {
+	// Optional existing DB instance. If provided, we will use it directly.
+	// Its DSN must set parseTime=true, loc=UTC and time_zone='+00:00'.
+	DBInstance *gorm.DB
+	// Optional DSN in the go-sql-driver/mysql format, e.g. "user:pass@tcp(host:3306)/db".
+	// If provided, it takes precedence over the individual connection params.
+	DSN string
+	// Database connection parameters for building the DSN string
+	User     string
+	Password string
+	Host     string
+	Port     int
+	DBName   string
+	// Optional name of a TLS config, e.g. "true", "skip-verify" or one registered with the driver
+	TLS string
+	// Optional outbox table name, defaults to "messages". Use different names to run several outboxes in one database.
+	TableName string
+	// Optional database holding the outbox table. The connection's database is used when empty.
+	Schema string
+	// SkipAutoMigrate disables running the embedded migrations on startup.
+	// The schema version is then only verified, and migrations must be run out-of-band with Migrate.
+	SkipAutoMigrate bool
+}
+
+// Validate validates the provided database configuration
+func (c *Config) Validate() error {
+	// Validate if DBInstance or DSN is provided or if all connection params are provided
+	if c.DBInstance == nil && c.DSN == "" && (c.User == "" || c.Password == "" || c.Host == "" || c.Port == 0 || c.DBName == "") {
+		return fmt.Errorf("either DBInstance, DSN or all database connection params (User, Password, Host, Port, DBName) must be provided")
+	}
+	if c.DBInstance == nil && c.DSN != "" {
+		if _, err := mysqldriver.ParseDSN(c.DSN); err != nil {
+			return fmt.Errorf("invalid MySQL DSN: %w", err)
+		}
+	}
+	return c.Table().Validate()
+}
+
+// Table returns the outbox table configured for this connection
+func (c *Config) Table() Table {
+	name := c.TableName
+	if name == "" {
+		name = DefaultTableName
+	}
+	return Table{Schema: c.Schema, Name: name}
+}
+
+// BuildDSN constructs the DSN string from the provided configuration. Times are always read
+// and written in UTC, overriding parseTime, loc and time_zone in a provided DSN.
+func (c *Config) BuildDSN() string {
+	config := mysqldriver.NewConfig()
+	if c.DSN != "" {
+		// A full DSN wins over the individual params, Validate rejects DSNs that do not parse
+		if parsed, err := mysqldriver.ParseDSN(c.DSN); err == nil {
+			config = parsed
+		}
+	} else {
+		config.User = c.User
+		config.Passwd = c.Password
+		config.Net = "tcp"
+		config.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+		config.DBName = c.DBName
+		config.TLSConfig = c.TLS
+	}
+
+	config.ParseTime = true
+	config.Loc = time.UTC
+	if config.Params == nil {
+		config.Params = map[string]string{}
+	}
+	config.Params["time_zone"] = utcTimeZone
+	return config.FormatDSN()
+}
@@ -0,0 +1,68 @@
+package mysql
+
+import (
+	"testing"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_BuildDSN_Success(t *testing.T) {
+	config := &Config{User: "outbox", Password: "p@ss:word", Host: "db.internal", Port: 3306, DBName: "shop", TLS: "true"}
+	require.NoError(t, config.Validate())
+
+	parsed, err := mysqldriver.ParseDSN(config.BuildDSN())
+	require.NoError(t, err)
+	assert.Equal(t, "outbox", parsed.User)
+	assert.Equal(t, "p@ss:word", parsed.Passwd)
+	assert.Equal(t, "db.internal:3306", parsed.Addr)
+	assert.Equal(t, "shop", parsed.DBName)
+	assert.Equal(t, "true", parsed.TLSConfig)
+	assert.True(t, parsed.ParseTime)
+	assert.Equal(t, "UTC", parsed.Loc.String())
+	assert.Equal(t, utcTimeZone, parsed.Params["time_zone"])
+}
+
+func TestConfig_BuildDSN_ForcesUTC(t *testing.T) {
+	config := &Config{DSN: "outbox:secret@tcp(localhost:3306)/shop?loc=Local&time_zone=%27SYSTEM%27&charset=utf8mb4"}
+	require.NoError(t, config.Validate())
+
+	parsed, err := mysqldriver.ParseDSN(config.BuildDSN())
+	require.NoError(t, err)
+	assert.Equal(t, "shop", parsed.DBName)
+	assert.True(t, parsed.ParseTime)
+	assert.Equal(t, "UTC", parsed.Loc.String())
+	assert.Equal(t, utcTimeZone, parsed.Params["time_zone"])
+	assert.Equal(t, "utf8mb4", parsed.Params["charset"])
+}
+
+func TestConfig_Validate_Failure(t *testing.T) {
+	assert.Error(t, (&Config{}).Validate())
+	assert.Error(t, (&Config{DSN: "not a dsn"}).Validate())
+	assert.Error(t, (&Config{DSN: "outbox:secret@tcp(localhost:3306)/shop", TableName: "events; DROP TABLE users"}).Validate())
+}
+
+func TestConfig_Table(t *testing.T) {
+	assert.Equal(t, Table{Name: DefaultTableName}, (&Config{}).Table())
+	assert.Equal(t, "billing.events", (&Config{Schema: "billing", TableName: "events"}).Table().String())
+	assert.Equal(t, "`billing`.`events`", Table{Schema: "billing", Name: "events"}.quoted())
+}
+
+func TestMigrationRender_CustomTable(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration %s should have version %d", m.Name, i+1)
+	}
+	latest, err := LatestSchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, migrations[len(migrations)-1].Version, latest)
+
+	sql, err := migrations[0].Render(templateData(Table{Schema: "billing", Name: "billing_outbox"}))
+	require.NoError(t, err)
+	assert.Contains(t, sql, "CREATE TABLE IF NOT EXISTS `billing`.`billing_outbox`")
+	assert.Contains(t, sql, "INDEX billing_outbox_pending_idx")
+	assert.NotContains(t, sql, "{{")
+}
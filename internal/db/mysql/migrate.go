@@ -0,0 +1,103 @@
+package mysql
+
+import (
+	"embed"
+	"fmt"
+
+	"github.com/outbox-go-sdk/internal/db"
+
+	"gorm.io/gorm"
+)
+
+// migrationLockTimeout is how many seconds a migration run waits for a concurrent one to finish
+const migrationLockTimeout = 60
+
+// migrationFiles holds the embedded migrations, one statement per file
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// dialect runs the migrations on one connection holding a named lock: MySQL commits DDL
+// implicitly, so a transaction would not serialize concurrent runs
+var dialect = db.Dialect{
+	Quote: "`",
+	Serialize: func(gormDB *gorm.DB, key string, fn func(conn *gorm.DB) error) error {
+		return gormDB.Connection(func(conn *gorm.DB) error {
+			// Lock names are limited to 64 characters, hash the qualified migrations table into one
+			var locked *int
+			if err := conn.Raw("SELECT GET_LOCK(MD5(?), ?)", key, migrationLockTimeout).Scan(&locked).Error; err != nil {
+				return err
+			}
+			if locked == nil || *locked != 1 {
+				return fmt.Errorf("timed out waiting for a concurrent migration of %s", key)
+			}
+			defer conn.Exec("DO RELEASE_LOCK(MD5(?))", key)
+			return fn(conn)
+		})
+	},
+	CreateSchema: "CREATE DATABASE IF NOT EXISTS %s",
+	MigrationsColumns: `table_name varchar(255) NOT NULL,
+		version    integer      NOT NULL,
+		applied_at datetime(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6)`,
+	TableExists: func(gormDB *gorm.DB, schema, name string) (bool, error) {
+		var exists bool
+		err := gormDB.Raw("SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?",
+			schema, name).Scan(&exists).Error
+		return exists, err
+	},
+}
+
+// migrationData is passed to the migration templates
+type migrationData struct {
+	// Table is the quoted, schema-qualified outbox table name
+	Table string
+	// Prefix is the bare table name, used to derive index names
+	Prefix string
+}
+
+// templateData returns the migration template data for the given outbox table
+func templateData(table Table) migrationData {
+	return migrationData{Table: table.quoted(), Prefix: table.Name}
+}
+
+// loadMigrations reads the embedded migrations ordered by version
+func loadMigrations() ([]db.Migration, error) {
+	return db.LoadMigrations(migrationFiles)
+}
+
+// LatestSchemaVersion returns the version of the newest embedded migration
+func LatestSchemaVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	return db.LatestVersion(migrations), nil
+}
+
+// Migrate applies all pending embedded migrations to the given outbox table.
+// It is safe to run concurrently from several processes.
+func Migrate(gormDB *gorm.DB, table Table) error {
+	if err := table.Validate(); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return db.Migrate(gormDB, dialect, table.Schema, table.Name, migrations, templateData(table))
+}
+
+// SchemaVersion returns the version of the last migration applied to the outbox table, or 0 if none
+func SchemaVersion(gormDB *gorm.DB, table Table) (int, error) {
+	return db.SchemaVersion(gormDB, dialect, table.Schema, table.Name)
+}
+
+// VerifySchema checks that the outbox table is at least at the schema version expected by this SDK.
+// A newer schema is accepted, so relays can keep running while a newer release rolls out.
+func VerifySchema(gormDB *gorm.DB, table Table) error {
+	required, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	return db.VerifySchema(gormDB, dialect, table.Schema, table.Name, required)
+}
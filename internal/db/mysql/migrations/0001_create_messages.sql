@@ -0,0 +1,31 @@
+-- Outbox table with the columns of the PostgreSQL schema. MySQL has no partial indexes,
+-- so the indexes of the relay's scans lead with the status instead.
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+    id                bigint unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    payload           longblob,
+    -- Payloads are always stored as bytes in MySQL, the column only mirrors the PostgreSQL schema
+    payload_json      json,
+    content_type      varchar(255),
+    content_encoding  varchar(32),
+    event_type        varchar(255),
+    encryption_key_id varchar(255),
+    encrypted_key     blob,
+    headers           text,
+    status            varchar(50) NOT NULL DEFAULT 'pending',
+    deliver_after     datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    tenant_id         varchar(255),
+    partition_key     varchar(255),
+    priority          smallint NOT NULL DEFAULT 0,
+    expires_at        datetime(6),
+    lease_owner       varchar(255),
+    lease_expires_at  datetime(6),
+    attempts          integer NOT NULL DEFAULT 0,
+    last_error        text,
+    processed_at      datetime(6),
+    created_at        datetime(6),
+    updated_at        datetime(6),
+    INDEX {{.Prefix}}_pending_idx (status, deliver_after, id),
+    INDEX {{.Prefix}}_pending_priority_idx (status, priority DESC, deliver_after, id),
+    INDEX {{.Prefix}}_tenant_pending_idx (tenant_id, status, deliver_after, id),
+    INDEX {{.Prefix}}_in_flight_idx (status, lease_expires_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4
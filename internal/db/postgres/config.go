@@ -10,6 +10,9 @@ import (
 type Config struct {
 	// Optional existing DB instance. If provided, we will use it directly.
 	DBInstance *gorm.DB
+	// Optional full connection URL (e.g. the DATABASE_URL environment variable).
+	// If provided, it takes precedence over the individual connection params.
+	URL string
 	// Database connection parameters for building the DSN string
 	User     string
 	Password string
@@ -17,19 +20,44 @@ type Config struct {
 	Port     int
 	DBName   string
 	SSLMode  string // Optional: set it to "disable" if you don't need SSL
+	// Optional outbox table name, defaults to "messages". Use different names to run several outboxes in one database.
+	TableName string
+	// Optional schema holding the outbox table. The connection's search_path is used when empty.
+	Schema string
+	// StoreJSONAsJSONB stores payloads with a JSON content type in the jsonb payload_json column instead of
+	// the bytea payload column, so they can be queried. Postgres normalizes jsonb, so whitespace and key order
+	// of the published payload may differ from the original.
+	StoreJSONAsJSONB bool
+	// SkipAutoMigrate disables running the embedded migrations on startup.
+	// The schema version is then only verified, and migrations must be run out-of-band with Migrate.
+	SkipAutoMigrate bool
 }
 
 // Validate validates the provided database configuration
 func (c *Config) Validate() error {
-	// Validate if DBInstance is provided or if all connection params are provided
-	if c.DBInstance == nil && (c.User == "" || c.Password == "" || c.Host == "" || c.Port == 0 || c.DBName == "") {
-		return fmt.Errorf("either DBInstance or all database connection params (User, Password, Host, Port, DBName) must be provided")
+	// Validate if DBInstance or URL is provided or if all connection params are provided
+	if c.DBInstance == nil && c.URL == "" && (c.User == "" || c.Password == "" || c.Host == "" || c.Port == 0 || c.DBName == "") {
+		return fmt.Errorf("either DBInstance, URL or all database connection params (User, Password, Host, Port, DBName) must be provided")
 	}
-	return nil
+	return c.Table().Validate()
+}
+
+// Table returns the outbox table configured for this connection
+func (c *Config) Table() Table {
+	name := c.TableName
+	if name == "" {
+		name = DefaultTableName
+	}
+	return Table{Schema: c.Schema, Name: name}
 }
 
 // BuildDSN constructs the DSN string from the provided configuration
 func (c *Config) BuildDSN() string {
+	// A full connection URL wins over the individual params
+	if c.URL != "" {
+		return c.URL
+	}
+
 	// Default SSLMode is "disable" if not provided
 	if c.SSLMode == "" {
 		c.SSLMode = "disable"
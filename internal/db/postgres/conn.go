@@ -0,0 +1,221 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lib/pq"
+)
+
+// errCallerTransaction is returned when committing or rolling back a transaction the caller owns
+var errCallerTransaction = errors.New("the transaction belongs to the caller, commit or roll it back there")
+
+// conn runs the statements of the native repositories over database/sql or pgx
+type conn interface {
+	exec(ctx context.Context, query string, args ...any) (int64, error)
+	query(ctx context.Context, query string, args ...any) (rows, error)
+	// array adapts a slice to an array parameter of the driver
+	array(values any) any
+	// begin starts a transaction, or a savepoint inside one
+	begin(ctx context.Context) (conn, error)
+	commit(ctx context.Context) error
+	rollback(ctx context.Context) error
+}
+
+// rows is the part of sql.Rows and pgx.Rows the native repositories read results with
+type rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+	Close()
+}
+
+// sqlDB runs statements on a database/sql connection pool
+type sqlDB struct {
+	db *sql.DB
+}
+
+func (c sqlDB) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	return sqlExec(c.db.ExecContext(ctx, query, args...))
+}
+
+func (c sqlDB) query(ctx context.Context, query string, args ...any) (rows, error) {
+	return sqlQuery(c.db.QueryContext(ctx, query, args...))
+}
+
+func (c sqlDB) array(values any) any {
+	return pq.Array(values)
+}
+
+func (c sqlDB) begin(ctx context.Context) (conn, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{tx: tx}, nil
+}
+
+func (c sqlDB) commit(context.Context) error {
+	return sql.ErrTxDone
+}
+
+func (c sqlDB) rollback(context.Context) error {
+	return sql.ErrTxDone
+}
+
+// sqlTx runs statements in a database/sql transaction. database/sql has no nested transactions,
+// so transactions begun inside it are savepoints.
+type sqlTx struct {
+	tx *sql.Tx
+	// savepoint names the savepoint this conn stands for, empty for the transaction itself
+	savepoint string
+	depth     int
+	// external is set for the caller's transaction, which the repository must not end
+	external bool
+}
+
+func (c *sqlTx) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	return sqlExec(c.tx.ExecContext(ctx, query, args...))
+}
+
+func (c *sqlTx) query(ctx context.Context, query string, args ...any) (rows, error) {
+	return sqlQuery(c.tx.QueryContext(ctx, query, args...))
+}
+
+func (c *sqlTx) array(values any) any {
+	return pq.Array(values)
+}
+
+func (c *sqlTx) begin(ctx context.Context) (conn, error) {
+	nested := &sqlTx{tx: c.tx, depth: c.depth + 1}
+	nested.savepoint = fmt.Sprintf("outbox_savepoint_%d", nested.depth)
+	if _, err := c.tx.ExecContext(ctx, "SAVEPOINT "+nested.savepoint); err != nil {
+		return nil, err
+	}
+	return nested, nil
+}
+
+func (c *sqlTx) commit(ctx context.Context) error {
+	if c.external {
+		return errCallerTransaction
+	}
+	if c.savepoint != "" {
+		_, err := c.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+c.savepoint)
+		return err
+	}
+	return c.tx.Commit()
+}
+
+func (c *sqlTx) rollback(ctx context.Context) error {
+	if c.external {
+		return errCallerTransaction
+	}
+	if c.savepoint != "" {
+		_, err := c.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+c.savepoint)
+		return err
+	}
+	return c.tx.Rollback()
+}
+
+func sqlExec(result sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func sqlQuery(result *sql.Rows, err error) (rows, error) {
+	if err != nil {
+		return nil, err
+	}
+	return sqlRows{result}, nil
+}
+
+// sqlRows drops the error of sql.Rows.Close, which Err reports as well
+type sqlRows struct {
+	*sql.Rows
+}
+
+func (r sqlRows) Close() {
+	_ = r.Rows.Close()
+}
+
+// pgxPool runs statements on a pgx connection pool
+type pgxPool struct {
+	pool *pgxpool.Pool
+}
+
+func (c pgxPool) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	tag, err := c.pool.Exec(ctx, query, args...)
+	return tag.RowsAffected(), err
+}
+
+func (c pgxPool) query(ctx context.Context, query string, args ...any) (rows, error) {
+	return c.pool.Query(ctx, query, args...)
+}
+
+func (c pgxPool) array(values any) any {
+	return values
+}
+
+func (c pgxPool) begin(ctx context.Context) (conn, error) {
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxTx{tx: tx}, nil
+}
+
+func (c pgxPool) commit(context.Context) error {
+	return pgx.ErrTxClosed
+}
+
+func (c pgxPool) rollback(context.Context) error {
+	return pgx.ErrTxClosed
+}
+
+// pgxTx runs statements in a pgx transaction, transactions begun inside it are savepoints
+type pgxTx struct {
+	tx pgx.Tx
+	// external is set for the caller's transaction, which the repository must not end
+	external bool
+}
+
+func (c *pgxTx) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	tag, err := c.tx.Exec(ctx, query, args...)
+	return tag.RowsAffected(), err
+}
+
+func (c *pgxTx) query(ctx context.Context, query string, args ...any) (rows, error) {
+	return c.tx.Query(ctx, query, args...)
+}
+
+func (c *pgxTx) array(values any) any {
+	return values
+}
+
+func (c *pgxTx) begin(ctx context.Context) (conn, error) {
+	tx, err := c.tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxTx{tx: tx}, nil
+}
+
+func (c *pgxTx) commit(ctx context.Context) error {
+	if c.external {
+		return errCallerTransaction
+	}
+	return c.tx.Commit(ctx)
+}
+
+func (c *pgxTx) rollback(ctx context.Context) error {
+	if c.external {
+		return errCallerTransaction
+	}
+	return c.tx.Rollback(ctx)
+}
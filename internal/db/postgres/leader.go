@@ -0,0 +1,230 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLeaderCheckInterval is how often followers try to take over and the leader checks its session
+const DefaultLeaderCheckInterval = 5 * time.Second
+
+// LeaderElector elects a single leader among relays sharing an outbox table
+type LeaderElector interface {
+	// IsLeader reports whether this instance held the leadership when last checked
+	IsLeader() bool
+	// VerifyLeader checks on the lock's session that this instance still holds the leadership,
+	// giving it up otherwise. Relays call it before each batch.
+	VerifyLeader() bool
+	// Run campaigns for the leadership until the context is cancelled, then releases it
+	Run(ctx context.Context)
+}
+
+// ElectionConfig holds the settings of a leader election
+type ElectionConfig struct {
+	// DB is the database the advisory lock is taken in, one of its connections is dedicated to the lock
+	DB *sql.DB
+	// Table is the outbox table the relays share, it names the lock
+	Table Table
+	// CheckInterval is how often followers try to take over and the leader checks its session
+	CheckInterval time.Duration
+	// OnElected and OnRevoked are called when this instance gains or loses the leadership
+	OnElected func()
+	OnRevoked func()
+}
+
+// Validate validates the election configuration
+func (c *ElectionConfig) Validate() error {
+	if c.DB == nil {
+		return fmt.Errorf("DB must be provided for leader election")
+	}
+	if c.CheckInterval < 0 {
+		return fmt.Errorf("leader check interval must not be negative")
+	}
+	return c.Table.Validate()
+}
+
+// lockSession is a database session able to hold the leader lock
+type lockSession interface {
+	// tryLock takes the lock if no other session holds it
+	tryLock(ctx context.Context) (bool, error)
+	// alive checks that the session still exists and holds the lock
+	alive(ctx context.Context) error
+	close() error
+}
+
+// advisoryLockElector elects the leader with a session-level pg_try_advisory_lock. The lock lives as
+// long as the dedicated connection, so when the leader's session dies PostgreSQL releases the lock
+// and the next follower to try takes over.
+type advisoryLockElector struct {
+	config     ElectionConfig
+	newSession func(ctx context.Context) (lockSession, error)
+	leader     atomic.Bool
+
+	// mu guards the session, checked by the election rounds and by the relay
+	mu      sync.Mutex
+	session lockSession
+}
+
+// NewLeaderElector creates a leader elector based on a PostgreSQL advisory lock
+func NewLeaderElector(config *ElectionConfig) (LeaderElector, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	e := &advisoryLockElector{config: *config}
+	if e.config.CheckInterval == 0 {
+		e.config.CheckInterval = DefaultLeaderCheckInterval
+	}
+	key := "outbox-leader:" + config.Table.String()
+	e.newSession = func(ctx context.Context) (lockSession, error) {
+		conn, err := config.DB.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &advisoryLockSession{conn: conn, key: key}, nil
+	}
+	return e, nil
+}
+
+// IsLeader reports whether this instance held the leadership when last checked
+func (e *advisoryLockElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// VerifyLeader checks on the dedicated connection that this instance still holds the leader lock
+func (e *advisoryLockElector) VerifyLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.IsLeader() || e.session == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.CheckInterval)
+	defer cancel()
+	return e.verify(ctx)
+}
+
+// Run campaigns for the leadership until the context is cancelled
+func (e *advisoryLockElector) Run(ctx context.Context) {
+	defer func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.closeSession()
+		e.setLeader(false)
+	}()
+
+	ticker := time.NewTicker(e.config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign runs one election round, opening a session if the previous one was lost
+func (e *advisoryLockElector) campaign(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.session == nil {
+		session, err := e.newSession(ctx)
+		if err != nil {
+			log.Printf("Error opening leader election session: %v", err)
+			return
+		}
+		e.session = session
+	}
+
+	if e.IsLeader() {
+		e.verify(ctx)
+		return
+	}
+
+	elected, err := e.session.tryLock(ctx)
+	if err != nil {
+		log.Printf("Error trying to take the leader lock: %v", err)
+		e.closeSession()
+		return
+	}
+	if elected {
+		e.setLeader(true)
+	}
+}
+
+// verify checks that the leader's session still holds the lock and steps down otherwise.
+// The mutex must be held.
+func (e *advisoryLockElector) verify(ctx context.Context) bool {
+	if err := e.session.alive(ctx); err != nil {
+		log.Printf("Leader election session lost, giving up the leadership: %v", err)
+		e.setLeader(false)
+		e.closeSession()
+		return false
+	}
+	return true
+}
+
+// closeSession ends the session, releasing the lock if it holds it. The mutex must be held.
+func (e *advisoryLockElector) closeSession() {
+	if e.session != nil {
+		e.session.close()
+		e.session = nil
+	}
+}
+
+// setLeader records the leadership and calls the hooks when it changes
+func (e *advisoryLockElector) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+	if leader && e.config.OnElected != nil {
+		e.config.OnElected()
+	}
+	if !leader && e.config.OnRevoked != nil {
+		e.config.OnRevoked()
+	}
+}
+
+// errLockReleased reports a live session that no longer holds the leader lock
+var errLockReleased = errors.New("leader lock released")
+
+// advisoryLockSession holds the leader lock on a dedicated connection
+type advisoryLockSession struct {
+	conn *sql.Conn
+	key  string
+}
+
+func (s *advisoryLockSession) tryLock(ctx context.Context) (bool, error) {
+	var locked bool
+	err := s.conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", s.key).Scan(&locked)
+	return locked, err
+}
+
+// alive looks the lock up among the locks granted to the session, a bigint advisory lock being
+// recorded as its high and low halves with objsubid 1
+func (s *advisoryLockSession) alive(ctx context.Context) error {
+	var held bool
+	if err := s.conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_locks
+		WHERE locktype = 'advisory' AND pid = pg_backend_pid() AND granted AND objsubid = 1
+		AND ((classid::bigint << 32) | objid::bigint) = hashtext($1)::bigint)`, s.key).Scan(&held); err != nil {
+		return err
+	}
+	if !held {
+		return errLockReleased
+	}
+	return nil
+}
+
+// close releases the lock by discarding the connection, ending its session, instead of returning it to the pool
+func (s *advisoryLockSession) close() error {
+	_ = s.conn.Raw(func(any) error { return driver.ErrBadConn })
+	return s.conn.Close()
+}
@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLock stands in for a PostgreSQL advisory lock shared by several sessions
+type fakeLock struct {
+	mu     sync.Mutex
+	holder *fakeSession
+}
+
+type fakeSession struct {
+	lock *fakeLock
+	dead bool
+}
+
+func (s *fakeSession) tryLock(context.Context) (bool, error) {
+	if s.dead {
+		return false, errors.New("session terminated")
+	}
+	s.lock.mu.Lock()
+	defer s.lock.mu.Unlock()
+	if s.lock.holder == nil || s.lock.holder == s {
+		s.lock.holder = s
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *fakeSession) alive(context.Context) error {
+	if s.dead {
+		return errors.New("session terminated")
+	}
+	return nil
+}
+
+// terminate ends the session like a server-side disconnect, releasing its lock
+func (s *fakeSession) terminate() {
+	s.dead = true
+	s.close()
+}
+
+func (s *fakeSession) close() error {
+	s.lock.mu.Lock()
+	defer s.lock.mu.Unlock()
+	if s.lock.holder == s {
+		s.lock.holder = nil
+	}
+	return nil
+}
+
+func newFakeElector(lock *fakeLock, events *[]string, name string) *advisoryLockElector {
+	return &advisoryLockElector{
+		config: ElectionConfig{
+			OnElected: func() { *events = append(*events, name+" elected") },
+			OnRevoked: func() { *events = append(*events, name+" revoked") },
+		},
+		newSession: func(context.Context) (lockSession, error) {
+			return &fakeSession{lock: lock}, nil
+		},
+	}
+}
+
+func TestLeaderElector_Failover(t *testing.T) {
+	ctx := context.Background()
+	lock := &fakeLock{}
+	var events []string
+	first := newFakeElector(lock, &events, "first")
+	second := newFakeElector(lock, &events, "second")
+
+	first.campaign(ctx)
+	second.campaign(ctx)
+	assert.True(t, first.IsLeader())
+	assert.False(t, second.IsLeader())
+
+	// The leader keeps the leadership while its session lives
+	first.campaign(ctx)
+	second.campaign(ctx)
+	assert.True(t, first.IsLeader())
+	assert.False(t, second.IsLeader())
+
+	// Its session dies: the lock is released, it steps down and the follower takes over
+	first.session.(*fakeSession).terminate()
+	first.campaign(ctx)
+	assert.Nil(t, first.session)
+	second.campaign(ctx)
+	assert.False(t, first.IsLeader())
+	assert.True(t, second.IsLeader())
+
+	assert.Equal(t, []string{"first elected", "first revoked", "second elected"}, events)
+}
+
+func TestLeaderElector_VerifyLeader(t *testing.T) {
+	lock := &fakeLock{}
+	var events []string
+	elector := newFakeElector(lock, &events, "relay")
+	elector.config.CheckInterval = DefaultLeaderCheckInterval
+	assert.False(t, elector.VerifyLeader())
+
+	elector.campaign(context.Background())
+	assert.True(t, elector.VerifyLeader())
+
+	// A lost session is noticed right away, without waiting for the next election round
+	elector.session.(*fakeSession).terminate()
+	assert.False(t, elector.VerifyLeader())
+	assert.False(t, elector.IsLeader())
+	assert.Equal(t, []string{"relay elected", "relay revoked"}, events)
+}
+
+func TestLeaderElector_RunReleasesOnCancel(t *testing.T) {
+	lock := &fakeLock{}
+	var events []string
+	elector := newFakeElector(lock, &events, "relay")
+	elector.config.CheckInterval = DefaultLeaderCheckInterval
+
+	ctx, cancel := context.WithCancel(context.Background())
+	elector.config.OnElected = func() { cancel() }
+	elector.Run(ctx)
+
+	assert.False(t, elector.IsLeader())
+	assert.Nil(t, lock.holder)
+	assert.Equal(t, []string{"relay revoked"}, events)
+}
+
+func TestNewLeaderElector_Failure_MissingDB(t *testing.T) {
+	_, err := NewLeaderElector(&ElectionConfig{Table: Table{Name: "messages"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"embed"
+	"fmt"
+
+	"github.com/outbox-go-sdk/internal/db"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// dialect runs the migrations in one transaction, serialized by a transaction-level advisory lock
+var dialect = db.Dialect{
+	Quote: `"`,
+	Serialize: func(gormDB *gorm.DB, key string, fn func(conn *gorm.DB) error) error {
+		return gormDB.Transaction(func(tx *gorm.DB) error {
+			// Serialize concurrent migration runs until this transaction ends
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", key).Error; err != nil {
+				return err
+			}
+			return fn(tx)
+		})
+	},
+	CreateSchema: "CREATE SCHEMA IF NOT EXISTS %s",
+	MigrationsColumns: `table_name varchar(255) NOT NULL,
+		version    integer      NOT NULL,
+		applied_at timestamptz  NOT NULL DEFAULT now()`,
+	TableExists: func(gormDB *gorm.DB, schema, name string) (bool, error) {
+		var exists bool
+		err := gormDB.Raw("SELECT to_regclass(?) IS NOT NULL", db.QuoteName(`"`, schema, name)).Scan(&exists).Error
+		return exists, err
+	},
+}
+
+// migrationData is passed to the migration templates
+type migrationData struct {
+	// Table is the quoted, schema-qualified outbox table name
+	Table string
+	// Prefix is the bare table name, used to derive index names
+	Prefix string
+	// SchemaPrefix qualifies index names in DROP INDEX, e.g. `"billing".`, or is empty
+	SchemaPrefix string
+	// RelayInstances and PartitionLeases are the quoted, schema-qualified sharding coordination tables
+	RelayInstances  string
+	PartitionLeases string
+}
+
+// templateData returns the migration template data for the given outbox table
+func templateData(table Table) migrationData {
+	data := migrationData{
+		Table:           table.quoted(),
+		Prefix:          table.Name,
+		RelayInstances:  table.relayInstances(),
+		PartitionLeases: table.partitionLeases(),
+	}
+	if table.Schema != "" {
+		data.SchemaPrefix = fmt.Sprintf("%q.", table.Schema)
+	}
+	return data
+}
+
+// loadMigrations reads the embedded migrations ordered by version
+func loadMigrations() ([]db.Migration, error) {
+	return db.LoadMigrations(migrationFiles)
+}
+
+// LatestSchemaVersion returns the version of the newest embedded migration
+func LatestSchemaVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	return db.LatestVersion(migrations), nil
+}
+
+// Migrate applies all pending embedded migrations to the given outbox table.
+// It is safe to run concurrently from several processes.
+func Migrate(gormDB *gorm.DB, table Table) error {
+	if err := table.Validate(); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return db.Migrate(gormDB, dialect, table.Schema, table.Name, migrations, templateData(table))
+}
+
+// SchemaVersion returns the version of the last migration applied to the outbox table, or 0 if none
+func SchemaVersion(gormDB *gorm.DB, table Table) (int, error) {
+	return db.SchemaVersion(gormDB, dialect, table.Schema, table.Name)
+}
+
+// VerifySchema checks that the outbox table is at least at the schema version expected by this SDK.
+// A newer schema is accepted, so relays can keep running while a newer release rolls out.
+func VerifySchema(gormDB *gorm.DB, table Table) error {
+	required, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	return db.VerifySchema(gormDB, dialect, table.Schema, table.Name, required)
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations_OrderedByVersion(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration %s should have version %d", m.Name, i+1)
+		sql, err := m.Render(templateData(Table{Name: DefaultTableName}))
+		require.NoError(t, err)
+		assert.NotEmpty(t, sql)
+	}
+	latest, err := LatestSchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, migrations[len(migrations)-1].Version, latest)
+}
+
+func TestMigrationRender_CustomTable(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+
+	sql, err := migrations[0].Render(templateData(Table{Schema: "billing", Name: "billing_outbox"}))
+	require.NoError(t, err)
+	assert.Contains(t, sql, `CREATE TABLE IF NOT EXISTS "billing"."billing_outbox"`)
+	assert.Contains(t, sql, `billing_outbox_pending_idx ON "billing"."billing_outbox"`)
+	assert.NotContains(t, sql, "{{")
+}
+
+func TestConfig_Table(t *testing.T) {
+	assert.Equal(t, Table{Name: DefaultTableName}, (&Config{}).Table())
+	assert.Equal(t, "billing.events", (&Config{Schema: "billing", TableName: "events"}).Table().String())
+
+	config := &Config{URL: "postgres://localhost/db", TableName: "events; DROP TABLE users"}
+	assert.Error(t, config.Validate())
+}
+
+func TestMigrationRender_DropIndexInSchema(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+
+	for _, m := range migrations {
+		sql, err := m.Render(templateData(Table{Schema: "billing", Name: "billing_outbox"}))
+		require.NoError(t, err)
+		if m.Version == 7 {
+			assert.Contains(t, sql, `DROP INDEX IF EXISTS "billing".billing_outbox_pending_idx`)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+-- Outbox table, compatible with the table previously created by gorm AutoMigrate
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+    id           bigserial PRIMARY KEY,
+    payload      text,
+    status       varchar(50) DEFAULT 'pending',
+    processed_at timestamptz,
+    created_at   timestamptz,
+    updated_at   timestamptz
+);
+
+-- Keeps the relay's scan for pending messages cheap once processed rows pile up
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_pending_idx ON {{.Table}} (id) WHERE status = 'pending';
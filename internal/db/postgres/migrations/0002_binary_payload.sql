@@ -0,0 +1,7 @@
+-- Store payloads as raw bytes so binary encodings such as protobuf survive unchanged
+ALTER TABLE {{.Table}} ALTER COLUMN payload TYPE bytea USING convert_to(payload, 'UTF8');
+
+-- JSON payloads can optionally be stored as jsonb instead, to make them queryable
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS payload_json jsonb;
+
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS content_type varchar(255);
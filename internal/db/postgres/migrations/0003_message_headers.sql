@@ -0,0 +1,2 @@
+-- Headers recorded at enqueue (e.g. the codec) and forwarded with the published message
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS headers jsonb;
@@ -0,0 +1,2 @@
+-- Event type of the message, e.g. "com.example.order.created", used as the CloudEvents type
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS event_type varchar(255);
@@ -0,0 +1,7 @@
+-- Envelope encryption: the payload is encrypted with a per-message data key,
+-- which is stored wrapped by the key-encryption key identified by encryption_key_id
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS encryption_key_id varchar(255);
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS encrypted_key bytea;
+
+-- Lets key rotation find the rows still encrypted with an old key
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_encryption_key_id_idx ON {{.Table}} (encryption_key_id) WHERE encryption_key_id IS NOT NULL;
@@ -0,0 +1,2 @@
+-- Compression applied to the payload at enqueue, e.g. "gzip" or "zstd"
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS content_encoding varchar(32);
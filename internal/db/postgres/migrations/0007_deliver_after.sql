@@ -0,0 +1,6 @@
+-- Messages are not published before deliver_after. Existing and immediate messages are due on insert.
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS deliver_after timestamptz NOT NULL DEFAULT now();
+
+-- The relay scans pending messages in due order, so index exactly that and drop the id-only index
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_pending_due_idx ON {{.Table}} (deliver_after, id) WHERE status = 'pending';
+DROP INDEX IF EXISTS {{.SchemaPrefix}}{{.Prefix}}_pending_idx;
@@ -0,0 +1,2 @@
+-- Messages still pending after expires_at are not published but moved to the 'expired' status
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS expires_at timestamptz;
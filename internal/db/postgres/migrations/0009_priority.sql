@@ -0,0 +1,5 @@
+-- Higher priorities are served first when the relay runs with priority lanes
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 0;
+
+-- Serves the per-lane scan: priority range, highest first, then due order
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_pending_priority_idx ON {{.Table}} (priority DESC, deliver_after, id) WHERE status = 'pending';
@@ -0,0 +1,15 @@
+-- Messages sharing a partition key land in the same partition and are published in order by its owner
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS partition_key varchar(255);
+
+-- Relay instances announce themselves with heartbeats so that partitions are rebalanced when they join or leave
+CREATE TABLE IF NOT EXISTS {{.RelayInstances}} (
+    instance_id  varchar(255) PRIMARY KEY,
+    heartbeat_at timestamptz  NOT NULL DEFAULT now()
+);
+
+-- Each partition is leased to at most one relay instance at a time
+CREATE TABLE IF NOT EXISTS {{.PartitionLeases}} (
+    partition_id integer      PRIMARY KEY,
+    owner        varchar(255) NOT NULL,
+    expires_at   timestamptz  NOT NULL
+);
@@ -0,0 +1,10 @@
+-- In-flight messages are leased to a relay worker while they are published outside of any transaction
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS lease_owner varchar(255);
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS lease_expires_at timestamptz;
+
+-- Retry bookkeeping: publish attempts so far and the error of the last failed one
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS last_error text;
+
+-- Serves the reaper returning expired leases to pending
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_in_flight_idx ON {{.Table}} (lease_expires_at) WHERE status = 'in_flight';
@@ -0,0 +1,6 @@
+-- Messages of a multi-tenant outbox belong to a tenant, NULL when the outbox is not shared
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS tenant_id varchar(255);
+
+-- Serves the per-tenant scan of the relay scheduler and the admin queries filtered by tenant
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_tenant_pending_idx ON {{.Table}} (tenant_id, deliver_after, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_tenant_status_idx ON {{.Table}} (tenant_id, status);
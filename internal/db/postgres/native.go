@@ -0,0 +1,671 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// SQLRepository is a Repository over database/sql that can join the caller's transactions
+type SQLRepository interface {
+	Repository
+	// WithTx returns a repository running its statements in the caller's transaction, so that messages
+	// are enqueued atomically with the caller's writes. The caller commits or rolls back the transaction,
+	// transactions begun from the returned repository are savepoints.
+	WithTx(tx *sql.Tx) Repository
+}
+
+// PgxRepository is a Repository over a pgx connection pool that can join the caller's transactions
+type PgxRepository interface {
+	Repository
+	// WithTx returns a repository running its statements in the caller's transaction, so that messages
+	// are enqueued atomically with the caller's writes. The caller commits or rolls back the transaction,
+	// transactions begun from the returned repository are savepoints.
+	WithTx(tx pgx.Tx) Repository
+}
+
+// SQLConfig holds the configuration of a repository over database/sql, for applications not using GORM
+type SQLConfig struct {
+	// DB is the caller's connection pool, opened with a PostgreSQL driver such as pgx's stdlib or lib/pq
+	DB *sql.DB
+	// Optional outbox table, defaults to "messages" in the connection's search_path
+	Table Table
+	// StoreJSONAsJSONB stores payloads with a JSON content type in the jsonb payload_json column, see Config
+	StoreJSONAsJSONB bool
+	// SkipAutoMigrate disables running the embedded migrations, the schema version is then only verified
+	SkipAutoMigrate bool
+}
+
+// Validate validates the database/sql repository configuration
+func (c *SQLConfig) Validate() error {
+	if c.DB == nil {
+		return fmt.Errorf("DB must be provided")
+	}
+	return defaultTable(c.Table).Validate()
+}
+
+// PgxConfig holds the configuration of a repository over a pgx connection pool
+type PgxConfig struct {
+	// Pool is the caller's pgx connection pool
+	Pool *pgxpool.Pool
+	// Optional outbox table, defaults to "messages" in the connection's search_path
+	Table Table
+	// StoreJSONAsJSONB stores payloads with a JSON content type in the jsonb payload_json column, see Config
+	StoreJSONAsJSONB bool
+	// SkipAutoMigrate disables running the embedded migrations, the schema version is then only verified
+	SkipAutoMigrate bool
+}
+
+// Validate validates the pgx repository configuration
+func (c *PgxConfig) Validate() error {
+	if c.Pool == nil {
+		return fmt.Errorf("pool must be provided")
+	}
+	return defaultTable(c.Table).Validate()
+}
+
+// defaultTable names the table "messages" when no name is configured
+func defaultTable(table Table) Table {
+	if table.Name == "" {
+		table.Name = DefaultTableName
+	}
+	return table
+}
+
+// nativeRepository implements Repository with hand-written SQL over database/sql or pgx
+type nativeRepository struct {
+	conn  conn
+	table Table
+	jsonb bool
+	// err holds the error of a failed BeginTransaction, returned by every method
+	err error
+}
+
+// NewSQLRepository creates a repository over the caller's database/sql connection pool
+func NewSQLRepository(config *SQLConfig) (SQLRepository, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	table := defaultTable(config.Table)
+	if err := prepareSchema(config.DB, table, config.SkipAutoMigrate); err != nil {
+		return nil, err
+	}
+	return sqlRepository{&nativeRepository{conn: sqlDB{db: config.DB}, table: table, jsonb: config.StoreJSONAsJSONB}}, nil
+}
+
+// NewPgxRepository creates a repository over the caller's pgx connection pool
+func NewPgxRepository(config *PgxConfig) (PgxRepository, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	table := defaultTable(config.Table)
+	db := stdlib.OpenDBFromPool(config.Pool)
+	defer db.Close()
+	if err := prepareSchema(db, table, config.SkipAutoMigrate); err != nil {
+		return nil, err
+	}
+	return pgxRepository{&nativeRepository{conn: pgxPool{pool: config.Pool}, table: table, jsonb: config.StoreJSONAsJSONB}}, nil
+}
+
+// prepareSchema migrates or verifies the outbox schema with the embedded migrations
+func prepareSchema(db *sql.DB, table Table, skipAutoMigrate bool) error {
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	if skipAutoMigrate {
+		return VerifySchema(gormDB, table)
+	}
+	return Migrate(gormDB, table)
+}
+
+// sqlRepository is the native repository over database/sql
+type sqlRepository struct {
+	*nativeRepository
+}
+
+// WithTx returns the repository running in the caller's database/sql transaction
+func (r sqlRepository) WithTx(tx *sql.Tx) Repository {
+	return r.with(&sqlTx{tx: tx, external: true}, nil)
+}
+
+// pgxRepository is the native repository over pgx
+type pgxRepository struct {
+	*nativeRepository
+}
+
+// WithTx returns the repository running in the caller's pgx transaction
+func (r pgxRepository) WithTx(tx pgx.Tx) Repository {
+	return r.with(&pgxTx{tx: tx, external: true}, nil)
+}
+
+// with returns a repository over another connection or transaction
+func (r *nativeRepository) with(conn conn, err error) *nativeRepository {
+	return &nativeRepository{conn: conn, table: r.table, jsonb: r.jsonb, err: err}
+}
+
+// messageColumns are the columns read into an outbox.Message, in scanMessages order
+const messageColumns = `id, payload, payload_json, content_type, content_encoding, event_type, encryption_key_id,
+	encrypted_key, headers, status, deliver_after, tenant_id, partition_key, priority, expires_at,
+	lease_owner, lease_expires_at, attempts, last_error, processed_at, created_at, updated_at`
+
+// insertColumns are the columns written by an INSERT, in insertValues order
+const insertColumns = `payload, payload_json, content_type, content_encoding, event_type, encryption_key_id,
+	encrypted_key, headers, status, deliver_after, tenant_id, partition_key, priority, expires_at, created_at, updated_at`
+
+// statement builds a query with numbered placeholders
+type statement struct {
+	sql  strings.Builder
+	args []any
+}
+
+// arg adds a parameter and returns its placeholder
+func (s *statement) arg(value any) string {
+	s.args = append(s.args, value)
+	return "$" + strconv.Itoa(len(s.args))
+}
+
+func (s *statement) write(parts ...string) *statement {
+	for _, part := range parts {
+		s.sql.WriteString(part)
+	}
+	return s
+}
+
+func (s *statement) String() string {
+	return s.sql.String()
+}
+
+// nullString stores the empty string as NULL, like the columns gorm writes with default:null
+func nullString(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(value time.Time) any {
+	if value.IsZero() {
+		return nil
+	}
+	return value
+}
+
+// nullJSON passes JSON as text, which both pgx and lib/pq convert to jsonb, and nil as NULL
+func nullJSON(value []byte) any {
+	if value == nil {
+		return nil
+	}
+	return string(value)
+}
+
+// insertValues adds the placeholders of one message's row to the INSERT statement
+func (r *nativeRepository) insertValues(s *statement, message outbox.Message) error {
+	message = storedMessage(message, r.jsonb)
+	headers, err := message.Headers.Value()
+	if err != nil {
+		return err
+	}
+	var headersJSON []byte
+	if headers != nil {
+		headersJSON = headers.([]byte)
+	}
+	status := message.Status
+	if status == "" {
+		status = outbox.StatusPending
+	}
+	s.write("(",
+		s.arg(message.Payload), ", ", s.arg(nullJSON(message.PayloadJSON)), ", ",
+		s.arg(message.ContentType), ", ", s.arg(message.ContentEncoding), ", ", s.arg(message.EventType), ", ",
+		s.arg(message.EncryptionKeyID), ", ", s.arg(message.EncryptedKey), ", ", s.arg(nullJSON(headersJSON)), ", ",
+		s.arg(status), ", COALESCE(", s.arg(nullTime(message.DeliverAfter)), "::timestamptz, now()), ",
+		s.arg(nullString(message.TenantID)), ", ", s.arg(nullString(message.PartitionKey)), ", ",
+		s.arg(message.Priority), ", ", s.arg(nullTime(message.ExpiresAt)), "::timestamptz, now(), now())")
+	return nil
+}
+
+// scanMessages reads the rows of a query selecting messageColumns
+func scanMessages(rows rows) ([]outbox.Message, error) {
+	defer rows.Close()
+	var messages []outbox.Message
+	for rows.Next() {
+		var (
+			message                                                      outbox.Message
+			id                                                           int64
+			contentType, contentEncoding, eventType, encryptionKeyID     *string
+			status, tenantID, partitionKey, leaseOwner, lastError        *string
+			headers                                                      []byte
+			expiresAt, leaseExpiresAt, processedAt, createdAt, updatedAt *time.Time
+		)
+		if err := rows.Scan(&id, &message.Payload, &message.PayloadJSON, &contentType, &contentEncoding, &eventType,
+			&encryptionKeyID, &message.EncryptedKey, &headers, &status, &message.DeliverAfter, &tenantID, &partitionKey,
+			&message.Priority, &expiresAt, &leaseOwner, &leaseExpiresAt, &message.Attempts, &lastError, &processedAt,
+			&createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		if headers != nil {
+			if err := message.Headers.Scan(headers); err != nil {
+				return nil, err
+			}
+		}
+		message.ID = uint(id)
+		message.ContentType, message.ContentEncoding, message.EventType = deref(contentType), deref(contentEncoding), deref(eventType)
+		message.EncryptionKeyID, message.Status, message.TenantID = deref(encryptionKeyID), deref(status), deref(tenantID)
+		message.PartitionKey, message.LeaseOwner, message.LastError = deref(partitionKey), deref(leaseOwner), deref(lastError)
+		message.ExpiresAt, message.LeaseExpiresAt, message.ProcessedAt = deref(expiresAt), deref(leaseExpiresAt), deref(processedAt)
+		message.CreatedAt, message.UpdatedAt = deref(createdAt), deref(updatedAt)
+		restorePayload(&message)
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// deref returns the value of a nullable column, the zero value for NULL
+func deref[T any](value *T) T {
+	if value == nil {
+		var zero T
+		return zero
+	}
+	return *value
+}
+
+// CreateOutboxMessage adds a new message to the outbox table
+func (r *nativeRepository) CreateOutboxMessage(message outbox.Message) error {
+	_, err := r.CreateOutboxMessages([]outbox.Message{message})
+	return err
+}
+
+// CreateOutboxMessages adds the messages to the outbox table with multi-row inserts and returns
+// their ids in the same order. Run it in a transaction to insert all messages or none.
+func (r *nativeRepository) CreateOutboxMessages(messages []outbox.Message) ([]uint, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	ids := make([]uint, 0, len(messages))
+	for start := 0; start < len(messages); start += insertBatchSize {
+		batch := messages[start:min(start+insertBatchSize, len(messages))]
+		s := &statement{}
+		s.write("INSERT INTO ", r.table.quoted(), " (", insertColumns, ") VALUES ")
+		for i, message := range batch {
+			if i > 0 {
+				s.write(", ")
+			}
+			if err := r.insertValues(s, message); err != nil {
+				return nil, err
+			}
+		}
+		s.write(" RETURNING id")
+
+		rows, err := r.conn.query(context.Background(), s.String(), s.args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids = append(ids, uint(id))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// BeginTransaction starts a new database transaction, or a savepoint when the repository is in one
+func (r *nativeRepository) BeginTransaction() Repository {
+	if r.err != nil {
+		return r
+	}
+	tx, err := r.conn.begin(context.Background())
+	return r.with(tx, err)
+}
+
+func (r *nativeRepository) CommitTransaction() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.conn.commit(context.Background())
+}
+
+func (r *nativeRepository) RollBackTransaction() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.conn.rollback(context.Background())
+}
+
+// FindUnprocessedMessages retrieves unprocessed outbox messages that are due, in batches.
+// The rows stay locked until the transaction ends and are skipped by concurrent relays.
+func (r *nativeRepository) FindUnprocessedMessages(batchSize int) ([]outbox.Message, error) {
+	return r.FindUnprocessedMessagesMatching(outbox.Filter{}, batchSize)
+}
+
+// FindUnprocessedMessagesMatching retrieves unprocessed outbox messages that are due and match the filter.
+// Messages restricted to a priority range are returned highest priority first.
+func (r *nativeRepository) FindUnprocessedMessagesMatching(filter outbox.Filter, batchSize int) ([]outbox.Message, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	s := &statement{}
+	s.write("SELECT ", messageColumns, " FROM ", r.table.quoted())
+	r.pending(s, filter, batchSize)
+	return r.queryMessages(s)
+}
+
+// ClaimMessages leases due messages matching the filter to the owner in a single statement, moving them
+// to the in-flight status until the lease expires. Messages are returned in the order FindUnprocessedMessagesMatching uses.
+func (r *nativeRepository) ClaimMessages(filter outbox.Filter, owner string, lease time.Duration, batchSize int) ([]outbox.Message, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	s := &statement{}
+	s.write("UPDATE ", r.table.quoted(), " SET status = ", s.arg(outbox.StatusInFlight),
+		", lease_owner = ", s.arg(owner),
+		", lease_expires_at = now() + ", s.arg(fmt.Sprintf("%d milliseconds", lease.Milliseconds())), "::interval",
+		", attempts = attempts + 1 WHERE id IN (SELECT id FROM ", r.table.quoted())
+	r.pending(s, filter, batchSize)
+	s.write(") RETURNING ", messageColumns)
+
+	messages, err := r.queryMessages(s)
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING does not keep the order of the claim
+	sort.Slice(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if filter.Priority != nil && a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.DeliverAfter.Equal(b.DeliverAfter) {
+			return a.DeliverAfter.Before(b.DeliverAfter)
+		}
+		return a.ID < b.ID
+	})
+	return messages, nil
+}
+
+// ReleaseMessage returns an in-flight message that failed to publish to pending, due again at retryAt.
+// It does nothing if the lease was lost to the reaper in the meantime.
+func (r *nativeRepository) ReleaseMessage(message outbox.Message, retryAt time.Time, lastError string) error {
+	s := &statement{}
+	s.write("UPDATE ", r.table.quoted(), " SET status = ", s.arg(outbox.StatusPending),
+		", lease_owner = NULL, lease_expires_at = NULL, deliver_after = ", s.arg(retryAt),
+		", last_error = ", s.arg(lastError),
+		" WHERE id = ", s.arg(int64(message.ID)), " AND status = ", s.arg(outbox.StatusInFlight),
+		" AND lease_owner = ", s.arg(message.LeaseOwner))
+	_, err := r.exec(s)
+	return err
+}
+
+// ReleaseExpiredLeases returns in-flight messages whose lease expired to pending.
+// It returns the number of released messages.
+func (r *nativeRepository) ReleaseExpiredLeases() (int64, error) {
+	s := &statement{}
+	s.write("UPDATE ", r.table.quoted(), " SET status = ", s.arg(outbox.StatusPending),
+		", lease_owner = NULL, lease_expires_at = NULL WHERE status = ", s.arg(outbox.StatusInFlight),
+		" AND lease_expires_at < now()")
+	return r.exec(s)
+}
+
+// MarkMessageAsProcessed marks a message as processed in the database
+func (r *nativeRepository) MarkMessageAsProcessed(message outbox.Message) error {
+	return r.MarkMessagesAsProcessed([]uint{message.ID})
+}
+
+// MarkMessagesAsProcessed marks all messages with the given ids as processed in a single UPDATE
+func (r *nativeRepository) MarkMessagesAsProcessed(ids []uint) error {
+	if r.err != nil || len(ids) == 0 {
+		return r.err
+	}
+	array := make([]int64, len(ids))
+	for i, id := range ids {
+		array[i] = int64(id)
+	}
+	s := &statement{}
+	s.write("UPDATE ", r.table.quoted(), " SET status = ", s.arg(outbox.StatusProcessed),
+		", processed_at = now() WHERE id = ANY(", s.arg(r.conn.array(array)), ")")
+	_, err := r.exec(s)
+	return err
+}
+
+// MarkLeasedMessagesAsProcessed marks the messages with the given ids still leased to the owner as processed
+// in a single UPDATE, leaving alone those whose lease was lost to the reaper or another relay. It returns the
+// number of messages marked.
+func (r *nativeRepository) MarkLeasedMessagesAsProcessed(owner string, ids []uint) (int64, error) {
+	if r.err != nil || len(ids) == 0 {
+		return 0, r.err
+	}
+	array := make([]int64, len(ids))
+	for i, id := range ids {
+		array[i] = int64(id)
+	}
+	s := &statement{}
+	s.write("UPDATE ", r.table.quoted(), " SET status = ", s.arg(outbox.StatusProcessed),
+		", processed_at = now() WHERE id = ANY(", s.arg(r.conn.array(array)), ") AND status = ", s.arg(outbox.StatusInFlight),
+		" AND lease_owner = ", s.arg(owner))
+	return r.exec(s)
+}
+
+// MarkMessageAsExpired moves a message that expired before being published to the expired status
+func (r *nativeRepository) MarkMessageAsExpired(message outbox.Message) error {
+	s := &statement{}
+	s.write("UPDATE ", r.table.quoted(), " SET status = ", s.arg(outbox.StatusExpired),
+		" WHERE id = ", s.arg(int64(message.ID)))
+	_, err := r.exec(s)
+	return err
+}
+
+// DeleteProcessedMessages deletes the messages processed, or expired, before the given time to keep the
+// outbox table from growing forever. Pending and in-flight messages are never deleted. It returns the
+// number of deleted messages.
+func (r *nativeRepository) DeleteProcessedMessages(before time.Time) (int64, error) {
+	s := &statement{}
+	s.write("DELETE FROM ", r.table.quoted(), " WHERE (status = ", s.arg(outbox.StatusProcessed),
+		" AND processed_at < ", s.arg(before), ") OR (status = ", s.arg(outbox.StatusExpired),
+		" AND expires_at < ", s.arg(before), ")")
+	return r.exec(s)
+}
+
+// FindMessagesToReencrypt retrieves and locks messages encrypted with a key other than the current one
+func (r *nativeRepository) FindMessagesToReencrypt(currentKeyID string, batchSize int) ([]outbox.Message, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	s := &statement{}
+	s.write("SELECT id, encryption_key_id, encrypted_key FROM ", r.table.quoted(),
+		" WHERE encryption_key_id IS NOT NULL AND encryption_key_id <> '' AND encryption_key_id <> ", s.arg(currentKeyID),
+		" ORDER BY id LIMIT ", s.arg(batchSize), " FOR UPDATE SKIP LOCKED")
+	rows, err := r.conn.query(context.Background(), s.String(), s.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var messages []outbox.Message
+	for rows.Next() {
+		var message outbox.Message
+		var id int64
+		if err := rows.Scan(&id, &message.EncryptionKeyID, &message.EncryptedKey); err != nil {
+			return nil, err
+		}
+		message.ID = uint(id)
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// UpdateMessageEncryption stores a rewrapped data key and its key id
+func (r *nativeRepository) UpdateMessageEncryption(message outbox.Message) error {
+	s := &statement{}
+	s.write("UPDATE ", r.table.quoted(), " SET encryption_key_id = ", s.arg(message.EncryptionKeyID),
+		", encrypted_key = ", s.arg(message.EncryptedKey), " WHERE id = ", s.arg(int64(message.ID)))
+	_, err := r.exec(s)
+	return err
+}
+
+// UpdateMessagePayload stores the encrypted payload of a message inserted before its id was known,
+// together with its wrapped data key and key id
+func (r *nativeRepository) UpdateMessagePayload(message outbox.Message) error {
+	s := &statement{}
+	s.write("UPDATE ", r.table.quoted(), " SET payload = ", s.arg(message.Payload),
+		", encryption_key_id = ", s.arg(message.EncryptionKeyID),
+		", encrypted_key = ", s.arg(message.EncryptedKey), " WHERE id = ", s.arg(int64(message.ID)))
+	_, err := r.exec(s)
+	return err
+}
+
+// ListMessages returns the messages matching the query ordered by id, for admin tooling
+func (r *nativeRepository) ListMessages(query outbox.Query) ([]outbox.Message, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	s := &statement{}
+	s.write("SELECT ", messageColumns, " FROM ", r.table.quoted(), " WHERE id > ", s.arg(int64(query.AfterID)))
+	r.matching(s, query)
+	s.write(" ORDER BY id")
+	if query.Limit > 0 {
+		s.write(" LIMIT ", s.arg(query.Limit))
+	}
+	return r.queryMessages(s)
+}
+
+// MessageStats counts the messages matching the query by tenant and status
+func (r *nativeRepository) MessageStats(query outbox.Query) ([]outbox.Stats, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	s := &statement{}
+	s.write("SELECT COALESCE(tenant_id, ''), status, count(*), min(created_at) FROM ", r.table.quoted(), " WHERE true")
+	r.matching(s, query)
+	s.write(" GROUP BY COALESCE(tenant_id, ''), status ORDER BY 1, 2")
+
+	rows, err := r.conn.query(context.Background(), s.String(), s.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []outbox.Stats
+	for rows.Next() {
+		var row outbox.Stats
+		var oldest *time.Time
+		if err := rows.Scan(&row.TenantID, &row.Status, &row.Count, &oldest); err != nil {
+			return nil, err
+		}
+		row.OldestCreatedAt = deref(oldest)
+		stats = append(stats, row)
+	}
+	return stats, rows.Err()
+}
+
+// FindTenantsWithPendingMessages returns the tenants with due messages matching the filter, sorted,
+// the empty tenant standing for messages without one
+func (r *nativeRepository) FindTenantsWithPendingMessages(filter outbox.Filter) ([]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	s := &statement{}
+	s.write("SELECT DISTINCT COALESCE(tenant_id, '') FROM ", r.table.quoted())
+	r.due(s, filter)
+
+	rows, err := r.conn.query(context.Background(), s.String(), s.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants, rows.Err()
+}
+
+// matching adds the conditions of an admin query to a statement with a WHERE clause
+func (r *nativeRepository) matching(s *statement, query outbox.Query) {
+	if query.TenantID != nil {
+		whereTenantIn(s, *query.TenantID)
+	}
+	if query.Status != "" {
+		s.write(" AND status = ", s.arg(query.Status))
+	}
+}
+
+// pending adds the WHERE, ORDER BY, LIMIT and locking clauses selecting the next due messages
+func (r *nativeRepository) pending(s *statement, filter outbox.Filter, batchSize int) {
+	r.due(s, filter)
+	s.write(" ORDER BY ")
+	if filter.Priority != nil {
+		s.write("priority DESC, ")
+	}
+	s.write("deliver_after, id LIMIT ", s.arg(batchSize), " FOR UPDATE")
+	// A sharded relay owns its partitions and waits for the rows a previous owner still locks
+	if filter.Partitions == nil {
+		s.write(" SKIP LOCKED")
+	}
+}
+
+// due adds the WHERE clause selecting due pending messages matching the filter
+func (r *nativeRepository) due(s *statement, filter outbox.Filter) {
+	s.write(" WHERE status = ", s.arg(outbox.StatusPending), " AND deliver_after <= now()")
+	if filter.Partitions != nil {
+		partitions := make([]int64, len(filter.Partitions.IDs))
+		for i, id := range filter.Partitions.IDs {
+			partitions[i] = int64(id)
+		}
+		s.write(" AND abs(hashtext(COALESCE(partition_key, id::text))::bigint) % ", s.arg(filter.Partitions.Count),
+			" = ANY(", s.arg(r.conn.array(partitions)), ")")
+	}
+	if filter.TenantID != nil {
+		whereTenantIn(s, *filter.TenantID)
+	}
+	if filter.Priority != nil {
+		s.write(" AND priority BETWEEN ", s.arg(filter.Priority.Min), " AND ", s.arg(filter.Priority.Max))
+	}
+}
+
+// whereTenantIn restricts the statement to a tenant, the empty tenant matching messages without one
+func whereTenantIn(s *statement, tenantID string) {
+	if tenantID == "" {
+		s.write(" AND tenant_id IS NULL")
+		return
+	}
+	s.write(" AND tenant_id = ", s.arg(tenantID))
+}
+
+// queryMessages runs a statement returning messageColumns
+func (r *nativeRepository) queryMessages(s *statement) ([]outbox.Message, error) {
+	rows, err := r.conn.query(context.Background(), s.String(), s.args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// exec runs a statement and returns the number of affected rows
+func (r *nativeRepository) exec(s *statement) (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.conn.exec(context.Background(), s.String(), s.args...)
+}
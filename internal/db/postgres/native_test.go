@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNativeRepository_ClaimStatement(t *testing.T) {
+	tenant := "acme"
+	filter := outbox.Filter{
+		Priority:   &outbox.PriorityRange{Min: 1, Max: 5},
+		Partitions: &outbox.PartitionSet{Count: 4, IDs: []int{1, 3}},
+		TenantID:   &tenant,
+	}
+	repo := &nativeRepository{conn: pgxPool{}, table: Table{Schema: "billing", Name: "events"}}
+
+	s := &statement{}
+	s.write("SELECT id FROM ", repo.table.quoted())
+	repo.pending(s, filter, 10)
+
+	assert.Equal(t, `SELECT id FROM "billing"."events" WHERE status = $1 AND deliver_after <= now()`+
+		` AND abs(hashtext(COALESCE(partition_key, id::text))::bigint) % $2 = ANY($3)`+
+		` AND tenant_id = $4 AND priority BETWEEN $5 AND $6`+
+		` ORDER BY priority DESC, deliver_after, id LIMIT $7 FOR UPDATE`, s.String())
+	assert.Equal(t, []any{outbox.StatusPending, 4, []int64{1, 3}, "acme", 1, 5, 10}, s.args)
+
+	// Unsharded relays skip the rows locked by each other
+	s = &statement{}
+	repo.pending(s, outbox.Filter{}, 10)
+	assert.Equal(t, ` WHERE status = $1 AND deliver_after <= now() ORDER BY deliver_after, id LIMIT $2 FOR UPDATE SKIP LOCKED`,
+		s.String())
+}
+
+func TestNativeRepository_InsertValues(t *testing.T) {
+	repo := &nativeRepository{conn: pgxPool{}, table: Table{Name: "messages"}, jsonb: true}
+	deliverAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s := &statement{}
+	require.NoError(t, repo.insertValues(s, outbox.Message{
+		Payload:      []byte(`{"a":1}`),
+		ContentType:  "application/json",
+		Headers:      outbox.Headers{"Trace-Id": "abc"},
+		DeliverAfter: deliverAfter,
+		TenantID:     "acme",
+	}))
+
+	require.Len(t, s.args, 14)
+	assert.Nil(t, s.args[0].([]byte), "JSON payloads move to the jsonb column")
+	assert.Equal(t, `{"a":1}`, s.args[1])
+	assert.Equal(t, `{"Trace-Id":"abc"}`, s.args[7])
+	assert.Equal(t, outbox.StatusPending, s.args[8])
+	assert.Equal(t, deliverAfter, s.args[9])
+	assert.Equal(t, "acme", s.args[10])
+	assert.Nil(t, s.args[11], "an empty partition key is stored as NULL")
+	assert.Nil(t, s.args[13], "no expiry is stored as NULL")
+}
+
+// failingConn cannot start transactions
+type failingConn struct {
+	pgxPool
+	err error
+}
+
+func (c failingConn) begin(context.Context) (conn, error) {
+	return nil, c.err
+}
+
+func TestNativeRepository_Failure_BeginTransaction(t *testing.T) {
+	beginErr := errors.New("connection refused")
+	repo := &nativeRepository{conn: failingConn{err: beginErr}, table: Table{Name: "messages"}}
+
+	tx := repo.BeginTransaction()
+	assert.ErrorIs(t, tx.CreateOutboxMessage(outbox.Message{}), beginErr)
+	_, err := tx.FindUnprocessedMessages(10)
+	assert.ErrorIs(t, err, beginErr)
+	assert.ErrorIs(t, tx.MarkMessagesAsProcessed([]uint{1}), beginErr)
+	assert.ErrorIs(t, tx.RollBackTransaction(), beginErr)
+}
+
+func TestSQLTx_SavepointsInCallerTransaction(t *testing.T) {
+	// SQLite stands in for PostgreSQL: both implement SAVEPOINT, RELEASE and ROLLBACK TO
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "savepoints.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE events (name text)")
+	require.NoError(t, err)
+
+	callerTx, err := db.Begin()
+	require.NoError(t, err)
+	caller := &sqlTx{tx: callerTx, external: true}
+
+	rolledBack, err := caller.begin(context.Background())
+	require.NoError(t, err)
+	_, err = rolledBack.exec(context.Background(), "INSERT INTO events VALUES ('rolled back')")
+	require.NoError(t, err)
+	require.NoError(t, rolledBack.rollback(context.Background()))
+
+	released, err := caller.begin(context.Background())
+	require.NoError(t, err)
+	_, err = released.exec(context.Background(), "INSERT INTO events VALUES ('released')")
+	require.NoError(t, err)
+	require.NoError(t, released.commit(context.Background()))
+
+	// The caller's transaction is theirs to end
+	assert.ErrorIs(t, caller.commit(context.Background()), errCallerTransaction)
+	require.NoError(t, callerTx.Commit())
+
+	var names []string
+	rows, err := db.Query("SELECT name FROM events")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"released"}, names)
+}
+
+func TestNewSQLRepository_Failure_MissingDB(t *testing.T) {
+	_, err := NewSQLRepository(&SQLConfig{})
+	assert.Error(t, err)
+	_, err = NewPgxRepository(&PgxConfig{})
+	assert.Error(t, err)
+}
@@ -1,80 +1,329 @@
 package postgres
 
 import (
+	"database/sql/driver"
+	"sort"
 	"time"
 
+	"github.com/outbox-go-sdk/internal/db"
 	"github.com/outbox-go-sdk/internal/domain/outbox"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type Repository interface {
-	// Methods to interact with the database
-	CreateOutboxMessage(message outbox.Message) error
-	FindUnprocessedMessages(batchSize int) ([]outbox.Message, error)
-	MarkMessageAsProcessed(message outbox.Message) error
-	BeginTransaction() Repository
-	RollBackTransaction() error
-	CommitTransaction() error
+// Repository is the storage interface, implemented here for PostgreSQL
+type Repository = db.Repository
+
+// insertBatchSize is the number of rows per INSERT statement of a bulk insert,
+// keeping each statement well below the PostgreSQL limit of 65535 bind parameters
+const insertBatchSize = 1000
+
+// bigintArray passes ids as one array parameter: gorm would expand a plain slice into a list of
+// parameters, while pgx encodes the []int64 returned by Value as a bigint[]
+type bigintArray []int64
+
+// Value returns the ids as a plain []int64 for pgx
+func (a bigintArray) Value() (driver.Value, error) {
+	return []int64(a), nil
+}
+
+// toBigintArray converts message ids or partition numbers to a bigintArray
+func toBigintArray[T uint | int](values []T) bigintArray {
+	array := make(bigintArray, len(values))
+	for i, value := range values {
+		array[i] = int64(value)
+	}
+	return array
 }
 
 type gormRepository struct {
-	db *gorm.DB
+	db    *gorm.DB
+	table Table
+	jsonb bool
 }
 
 func NewGormRepository(config *Config) (Repository, error) {
-	var db *gorm.DB
-	var err error
+	db, err := Open(config)
+	if err != nil {
+		return nil, err
+	}
 
-	// Use provided DB instance or create a new connection
-	if config.DBInstance != nil {
-		db = config.DBInstance
+	// Bring the outbox schema up to date, or only check it when migrations are run out-of-band
+	table := config.Table()
+	if config.SkipAutoMigrate {
+		err = VerifySchema(db, table)
 	} else {
-		dsn := config.BuildDSN()
-		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-		if err != nil {
-			return nil, err
-		}
+		err = Migrate(db, table)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Auto-migrate the OutboxMessage model
-	if err := db.AutoMigrate(&outbox.Message{}); err != nil {
+	return &gormRepository{db: db, table: table, jsonb: config.StoreJSONAsJSONB}, nil
+}
+
+// Open returns the provided DB instance or opens a new connection from the config
+func Open(config *Config) (*gorm.DB, error) {
+	// Validate the configuration
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
-	return &gormRepository{db: db}, nil
+	// Use provided DB instance or create a new connection
+	if config.DBInstance != nil {
+		return config.DBInstance, nil
+	}
+	return gorm.Open(postgres.Open(config.BuildDSN()), &gorm.Config{})
 }
 
 // CreateOutboxMessage adds a new message to the outbox table
 func (r *gormRepository) CreateOutboxMessage(message outbox.Message) error {
-	if err := r.db.Create(&message).Error; err != nil {
+	message = r.storedMessage(message)
+	if err := r.db.Table(r.table.String()).Create(&message).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
+// CreateOutboxMessages adds the messages to the outbox table with multi-row inserts and returns
+// their ids in the same order. Run it in a transaction to insert all messages or none.
+func (r *gormRepository) CreateOutboxMessages(messages []outbox.Message) ([]uint, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	rows := make([]outbox.Message, len(messages))
+	for i, message := range messages {
+		rows[i] = r.storedMessage(message)
+	}
+	if err := r.db.Table(r.table.String()).CreateInBatches(&rows, insertBatchSize).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids, nil
+}
+
+// storedMessage returns the message as it is stored, keeping JSON payloads queryable as jsonb when enabled
+func (r *gormRepository) storedMessage(message outbox.Message) outbox.Message {
+	return storedMessage(message, r.jsonb)
+}
+
+// storedMessage moves JSON payloads to the jsonb column when enabled
+func storedMessage(message outbox.Message, jsonb bool) outbox.Message {
+	if jsonb && !message.IsEncrypted() && !message.IsCompressed() && outbox.IsJSONContentType(message.ContentType) {
+		message.PayloadJSON, message.Payload = message.Payload, nil
+	}
+	return message
+}
+
 // BeginTransaction starts a new database transaction
 func (r *gormRepository) BeginTransaction() Repository {
 	return &gormRepository{
-		db: r.db.Begin(),
+		db:    r.db.Begin(),
+		table: r.table,
+		jsonb: r.jsonb,
 	}
 }
 
-// FindUnprocessedMessages retrieves unprocessed outbox messages in batches
+// FindUnprocessedMessages retrieves unprocessed outbox messages that are due, in batches.
+// The rows stay locked until the transaction ends and are skipped by concurrent relays.
 func (r *gormRepository) FindUnprocessedMessages(batchSize int) ([]outbox.Message, error) {
+	return r.FindUnprocessedMessagesMatching(outbox.Filter{}, batchSize)
+}
+
+// FindUnprocessedMessagesMatching retrieves unprocessed outbox messages that are due and match the filter.
+// Messages restricted to a priority range are returned highest priority first.
+func (r *gormRepository) FindUnprocessedMessagesMatching(filter outbox.Filter, batchSize int) ([]outbox.Message, error) {
+	var messages []outbox.Message
+	if err := r.pending(filter).
+		Limit(batchSize).
+		Clauses(claimLocking(filter)).
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		restorePayload(&messages[i])
+	}
+	return messages, nil
+}
+
+// ClaimMessages leases due messages matching the filter to the owner in a single statement, moving them
+// to the in-flight status until the lease expires. The claim commits on its own, so the messages can be
+// published outside of any transaction. Messages are returned in the order FindUnprocessedMessagesMatching uses.
+func (r *gormRepository) ClaimMessages(filter outbox.Filter, owner string, lease time.Duration, batchSize int) ([]outbox.Message, error) {
+	claimable := r.pending(filter).
+		Select("id").
+		Limit(batchSize).
+		Clauses(claimLocking(filter))
+
 	var messages []outbox.Message
-	if err := r.db.Where("status = ?", "pending").Limit(batchSize).Find(&messages).Error; err != nil {
+	if err := r.db.Table(r.table.String()).Model(&messages).
+		Clauses(clause.Returning{}).
+		Where("id IN (?)", claimable).
+		UpdateColumns(map[string]interface{}{
+			"status":           outbox.StatusInFlight,
+			"lease_owner":      owner,
+			"lease_expires_at": gorm.Expr("now() + ? * interval '1 millisecond'", lease.Milliseconds()),
+			"attempts":         gorm.Expr("attempts + 1"),
+		}).Error; err != nil {
 		return nil, err
 	}
+
+	// RETURNING does not keep the order of the claim
+	sort.Slice(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if filter.Priority != nil && a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.DeliverAfter.Equal(b.DeliverAfter) {
+			return a.DeliverAfter.Before(b.DeliverAfter)
+		}
+		return a.ID < b.ID
+	})
+	for i := range messages {
+		restorePayload(&messages[i])
+	}
 	return messages, nil
 }
 
+// ReleaseMessage returns an in-flight message that failed to publish to pending, due again at retryAt.
+// It does nothing if the lease was lost to the reaper in the meantime.
+func (r *gormRepository) ReleaseMessage(message outbox.Message, retryAt time.Time, lastError string) error {
+	if err := r.db.Table(r.table.String()).
+		Where("id = ? AND status = ? AND lease_owner = ?", message.ID, outbox.StatusInFlight, message.LeaseOwner).
+		UpdateColumns(map[string]interface{}{
+			"status":           outbox.StatusPending,
+			"lease_owner":      nil,
+			"lease_expires_at": nil,
+			"deliver_after":    retryAt,
+			"last_error":       lastError,
+		}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReleaseExpiredLeases returns in-flight messages whose lease expired, e.g. after their worker crashed,
+// to pending so they are published again. It returns the number of released messages.
+func (r *gormRepository) ReleaseExpiredLeases() (int64, error) {
+	result := r.db.Table(r.table.String()).
+		Where("status = ? AND lease_expires_at < now()", outbox.StatusInFlight).
+		UpdateColumns(map[string]interface{}{
+			"status":           outbox.StatusPending,
+			"lease_owner":      nil,
+			"lease_expires_at": nil,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// ListMessages returns the messages matching the query ordered by id, for admin tooling
+func (r *gormRepository) ListMessages(query outbox.Query) ([]outbox.Message, error) {
+	var messages []outbox.Message
+	db := r.matching(query).Where("id > ?", query.AfterID).Order("id")
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	if err := db.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		restorePayload(&messages[i])
+	}
+	return messages, nil
+}
+
+// MessageStats counts the messages matching the query by tenant and status
+func (r *gormRepository) MessageStats(query outbox.Query) ([]outbox.Stats, error) {
+	var stats []outbox.Stats
+	if err := r.matching(query).
+		Select("COALESCE(tenant_id, '') AS tenant_id, status, count(*) AS count, min(created_at) AS oldest_created_at").
+		Group("COALESCE(tenant_id, ''), status").
+		Order("tenant_id, status").
+		Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// matching returns the query for the messages matching an admin query
+func (r *gormRepository) matching(query outbox.Query) *gorm.DB {
+	db := r.db.Table(r.table.String())
+	if query.TenantID != nil {
+		db = whereTenant(db, *query.TenantID)
+	}
+	if query.Status != "" {
+		db = db.Where("status = ?", query.Status)
+	}
+	return db
+}
+
+// pending returns the query for due pending messages matching the filter, in publishing order
+func (r *gormRepository) pending(filter outbox.Filter) *gorm.DB {
+	query := r.due(filter)
+	if filter.Priority != nil {
+		query = query.Order("priority DESC")
+	}
+	return query.Order("deliver_after, id")
+}
+
+// due returns the query for due pending messages matching the filter
+func (r *gormRepository) due(filter outbox.Filter) *gorm.DB {
+	query := r.db.Table(r.table.String()).
+		Where("status = ? AND deliver_after <= now()", outbox.StatusPending)
+	if filter.Partitions != nil {
+		query = query.Where("abs(hashtext(COALESCE(partition_key, id::text))::bigint) % ? = ANY(?)",
+			filter.Partitions.Count, toBigintArray(filter.Partitions.IDs))
+	}
+	if filter.TenantID != nil {
+		query = whereTenant(query, *filter.TenantID)
+	}
+	if filter.Priority != nil {
+		query = query.Where("priority BETWEEN ? AND ?", filter.Priority.Min, filter.Priority.Max)
+	}
+	return query
+}
+
+// claimLocking returns the locking clause of a claim. Relays skip the rows locked by each other, but a
+// sharded relay owns its partitions and waits for the rows a previous owner still locks, so that it never
+// publishes a message ahead of an earlier one with the same partition key.
+func claimLocking(filter outbox.Filter) clause.Locking {
+	if filter.Partitions != nil {
+		return clause.Locking{Strength: "UPDATE"}
+	}
+	return clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}
+}
+
+// whereTenant restricts the query to a tenant, the empty tenant matching messages without one
+func whereTenant(query *gorm.DB, tenantID string) *gorm.DB {
+	if tenantID == "" {
+		return query.Where("tenant_id IS NULL")
+	}
+	return query.Where("tenant_id = ?", tenantID)
+}
+
+// FindTenantsWithPendingMessages returns the tenants with due messages matching the filter, sorted,
+// the empty tenant standing for messages without one
+func (r *gormRepository) FindTenantsWithPendingMessages(filter outbox.Filter) ([]string, error) {
+	var tenants []string
+	if err := r.due(filter).
+		Distinct().
+		Pluck("COALESCE(tenant_id, '')", &tenants).Error; err != nil {
+		return nil, err
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
 // MarkMessageAsProcessed marks a message as processed in the database
 func (r *gormRepository) MarkMessageAsProcessed(message outbox.Message) error {
 	processedAt := time.Now()
-	if err := r.db.Model(&message).UpdateColumns(map[string]interface{}{
-		"status":       "processed",
+	if err := r.db.Table(r.table.String()).Model(&message).UpdateColumns(map[string]interface{}{
+		"status":       outbox.StatusProcessed,
 		"processed_at": processedAt,
 	}).Error; err != nil {
 		return err
@@ -82,6 +331,96 @@ func (r *gormRepository) MarkMessageAsProcessed(message outbox.Message) error {
 	return nil
 }
 
+// MarkMessagesAsProcessed marks all messages with the given ids as processed in a single UPDATE
+func (r *gormRepository) MarkMessagesAsProcessed(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := r.db.Table(r.table.String()).Where("id = ANY(?)", toBigintArray(ids)).UpdateColumns(map[string]interface{}{
+		"status":       outbox.StatusProcessed,
+		"processed_at": time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarkLeasedMessagesAsProcessed marks the messages with the given ids still leased to the owner as processed
+// in a single UPDATE, leaving alone those whose lease was lost to the reaper or another relay. It returns the
+// number of messages marked.
+func (r *gormRepository) MarkLeasedMessagesAsProcessed(owner string, ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := r.db.Table(r.table.String()).
+		Where("id = ANY(?) AND status = ? AND lease_owner = ?", toBigintArray(ids), outbox.StatusInFlight, owner).
+		UpdateColumns(map[string]interface{}{
+			"status":       outbox.StatusProcessed,
+			"processed_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// MarkMessageAsExpired moves a message that expired before being published to the expired status
+func (r *gormRepository) MarkMessageAsExpired(message outbox.Message) error {
+	if err := r.db.Table(r.table.String()).Model(&message).UpdateColumns(map[string]interface{}{
+		"status": outbox.StatusExpired,
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteProcessedMessages deletes the messages processed, or expired, before the given time to keep the
+// outbox table from growing forever. Pending and in-flight messages are never deleted. It returns the
+// number of deleted messages.
+func (r *gormRepository) DeleteProcessedMessages(before time.Time) (int64, error) {
+	result := r.db.Table(r.table.String()).
+		Where("(status = ? AND processed_at < ?) OR (status = ? AND expires_at < ?)",
+			outbox.StatusProcessed, before, outbox.StatusExpired, before).
+		Delete(&outbox.Message{})
+	return result.RowsAffected, result.Error
+}
+
+// FindMessagesToReencrypt retrieves and locks messages encrypted with a key other than the current one
+func (r *gormRepository) FindMessagesToReencrypt(currentKeyID string, batchSize int) ([]outbox.Message, error) {
+	var messages []outbox.Message
+	if err := r.db.Table(r.table.String()).
+		Select("id", "encryption_key_id", "encrypted_key").
+		Where("encryption_key_id IS NOT NULL AND encryption_key_id <> '' AND encryption_key_id <> ?", currentKeyID).
+		Order("id").
+		Limit(batchSize).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// UpdateMessageEncryption stores a rewrapped data key and its key id
+func (r *gormRepository) UpdateMessageEncryption(message outbox.Message) error {
+	if err := r.db.Table(r.table.String()).Where("id = ?", message.ID).UpdateColumns(map[string]interface{}{
+		"encryption_key_id": message.EncryptionKeyID,
+		"encrypted_key":     message.EncryptedKey,
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateMessagePayload stores the encrypted payload of a message inserted before its id was known,
+// together with its wrapped data key and key id
+func (r *gormRepository) UpdateMessagePayload(message outbox.Message) error {
+	if err := r.db.Table(r.table.String()).Where("id = ?", message.ID).UpdateColumns(map[string]interface{}{
+		"payload":           message.Payload,
+		"encryption_key_id": message.EncryptionKeyID,
+		"encrypted_key":     message.EncryptedKey,
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
 func (r *gormRepository) CommitTransaction() error {
 	return r.db.Commit().Error
 }
@@ -89,3 +428,10 @@ func (r *gormRepository) CommitTransaction() error {
 func (r *gormRepository) RollBackTransaction() error {
 	return r.db.Rollback().Error
 }
+
+// restorePayload moves a payload stored as jsonb back into Payload
+func restorePayload(message *outbox.Message) {
+	if message.Payload == nil && message.PayloadJSON != nil {
+		message.Payload, message.PayloadJSON = message.PayloadJSON, nil
+	}
+}
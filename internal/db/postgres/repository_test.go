@@ -0,0 +1,25 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestBigintArray_SingleParameter(t *testing.T) {
+	dryDB, err := gorm.Open(postgres.New(postgres.Config{DSN: "postgres://u:p@localhost:5432/db"}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	require.NoError(t, err)
+
+	var ids []uint
+	stmt := dryDB.Table("messages").Where("id = ANY(?)", toBigintArray([]uint{3, 1, 2})).Pluck("id", &ids).Statement
+	assert.Contains(t, stmt.SQL.String(), "id = ANY($1)")
+	require.Len(t, stmt.Vars, 1)
+
+	value, err := stmt.Vars[0].(bigintArray).Value()
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 1, 2}, value)
+}
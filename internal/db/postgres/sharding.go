@@ -0,0 +1,207 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+
+	"github.com/lib/pq"
+)
+
+// DefaultPartitionLeaseTTL is how long a partition lease lasts without being renewed
+const DefaultPartitionLeaseTTL = 30 * time.Second
+
+// PartitionCoordinator leases outbox partitions to relay instances
+type PartitionCoordinator interface {
+	// Partitions returns the partitions leased to this instance. It renews the leases and
+	// rebalances the partitions across the live instances once a third of the lease TTL has passed.
+	Partitions() (outbox.PartitionSet, error)
+	// Leave releases the instance's partitions so the other instances take them over right away
+	Leave() error
+}
+
+// ShardingConfig holds the settings of a sharded relay
+type ShardingConfig struct {
+	// DB is the database holding the outbox table and its coordination tables
+	DB *sql.DB
+	// Table is the outbox table the relays share
+	Table Table
+	// Partitions is the number of partitions the outbox is split into, it must be the same for all instances
+	Partitions int
+	// InstanceID identifies this relay instance, defaults to the host name and process id
+	InstanceID string
+	// LeaseTTL is how long a partition lease lasts without renewal, and how long an instance that
+	// stopped sending heartbeats keeps its share. Defaults to DefaultPartitionLeaseTTL.
+	LeaseTTL time.Duration
+}
+
+// Validate validates the sharding configuration
+func (c *ShardingConfig) Validate() error {
+	if c.DB == nil {
+		return fmt.Errorf("DB must be provided for sharding")
+	}
+	if c.Partitions < 1 {
+		return fmt.Errorf("partitions must be at least 1, got %d", c.Partitions)
+	}
+	if c.LeaseTTL < 0 {
+		return fmt.Errorf("partition lease TTL must not be negative")
+	}
+	return c.Table.Validate()
+}
+
+// leaseCoordinator implements PartitionCoordinator with the relay instance and partition lease tables
+type leaseCoordinator struct {
+	config ShardingConfig
+
+	mu       sync.Mutex
+	owned    []int
+	syncedAt time.Time
+	now      func() time.Time
+}
+
+// NewPartitionCoordinator creates a partition coordinator based on leases in the database
+func NewPartitionCoordinator(config *ShardingConfig) (PartitionCoordinator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	c := &leaseCoordinator{config: *config, now: time.Now}
+	if c.config.LeaseTTL == 0 {
+		c.config.LeaseTTL = DefaultPartitionLeaseTTL
+	}
+	if c.config.InstanceID == "" {
+		c.config.InstanceID = DefaultInstanceID()
+	}
+	return c, nil
+}
+
+// Partitions returns the partitions leased to this instance, synchronizing with the other instances when due
+func (c *leaseCoordinator) Partitions() (outbox.PartitionSet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.syncedAt.IsZero() || c.now().Sub(c.syncedAt) >= c.config.LeaseTTL/3 {
+		owned, err := c.sync(context.Background())
+		if err != nil {
+			// Leases that are not renewed may be taken over, stop publishing their partitions
+			c.owned, c.syncedAt = nil, time.Time{}
+			return outbox.PartitionSet{Count: c.config.Partitions}, err
+		}
+		c.owned, c.syncedAt = owned, c.now()
+	}
+	return outbox.PartitionSet{Count: c.config.Partitions, IDs: slices.Clone(c.owned)}, nil
+}
+
+// sync sends a heartbeat, releases the partitions now assigned to other instances and
+// acquires or renews the leases of the partitions assigned to this one
+func (c *leaseCoordinator) sync(ctx context.Context) ([]int, error) {
+	tx, err := c.config.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	instances, leases := c.config.Table.relayInstances(), c.config.Table.partitionLeases()
+	ttl := c.config.LeaseTTL.Milliseconds()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (instance_id, heartbeat_at) VALUES ($1, now())
+		ON CONFLICT (instance_id) DO UPDATE SET heartbeat_at = excluded.heartbeat_at`, instances), c.config.InstanceID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE heartbeat_at < now() - $1 * interval '1 millisecond'`, instances), ttl); err != nil {
+		return nil, err
+	}
+
+	var live []string
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT instance_id FROM %s ORDER BY instance_id`, instances))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		live = append(live, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	assigned := assignPartitions(c.config.Partitions, live, c.config.InstanceID)
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE owner = $1 AND partition_id <> ALL($2)`, leases),
+		c.config.InstanceID, pq.Array(assigned)); err != nil {
+		return nil, err
+	}
+
+	// Take free or expired leases and renew our own, partitions still leased to another instance are skipped
+	var owned []int
+	rows, err = tx.QueryContext(ctx, fmt.Sprintf(`INSERT INTO %[1]s AS lease (partition_id, owner, expires_at)
+		SELECT p, $1, now() + $2 * interval '1 millisecond' FROM unnest($3::integer[]) AS p
+		ON CONFLICT (partition_id) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE lease.owner = excluded.owner OR lease.expires_at < now()
+		RETURNING partition_id`, leases), c.config.InstanceID, ttl, pq.Array(assigned))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var partition int
+		if err := rows.Scan(&partition); err != nil {
+			return nil, err
+		}
+		owned = append(owned, partition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(owned)
+	return owned, tx.Commit()
+}
+
+// Leave releases the instance's leases and removes it from the live instances
+func (c *leaseCoordinator) Leave() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.owned, c.syncedAt = nil, time.Time{}
+
+	tx, err := c.config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE owner = $1`, c.config.Table.partitionLeases()), c.config.InstanceID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE instance_id = $1`, c.config.Table.relayInstances()), c.config.InstanceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DefaultInstanceID identifies the relay process by its host name and process id
+func DefaultInstanceID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// assignPartitions returns the partitions assigned to the instance among the live instances,
+// dealing the partitions round-robin over the instances sorted by id
+func assignPartitions(partitions int, live []string, instanceID string) []int {
+	index := slices.Index(live, instanceID)
+	if index < 0 {
+		return []int{}
+	}
+	assigned := []int{}
+	for partition := index; partition < partitions; partition += len(live) {
+		assigned = append(assigned, partition)
+	}
+	return assigned
+}
@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssignPartitions(t *testing.T) {
+	live := []string{"relay-a", "relay-b", "relay-c"}
+
+	assert.Equal(t, []int{0, 3, 6}, assignPartitions(8, live, "relay-a"))
+	assert.Equal(t, []int{1, 4, 7}, assignPartitions(8, live, "relay-b"))
+	assert.Equal(t, []int{2, 5}, assignPartitions(8, live, "relay-c"))
+
+	// An instance not seen as live yet gets nothing until the next rebalance
+	assert.Empty(t, assignPartitions(8, live, "relay-d"))
+
+	// With more instances than partitions the extra instances stay idle
+	assert.Empty(t, assignPartitions(2, live, "relay-c"))
+}
+
+func TestNewPartitionCoordinator_Failure_Validation(t *testing.T) {
+	_, err := NewPartitionCoordinator(&ShardingConfig{Table: Table{Name: "messages"}, Partitions: 8})
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import "github.com/outbox-go-sdk/internal/db"
+
+// DefaultTableName is the outbox table used when no table name is configured
+const DefaultTableName = "messages"
+
+// Table identifies the outbox table, optionally inside a dedicated schema
+type Table struct {
+	// Schema is optional, the connection's search_path is used when empty
+	Schema string
+	Name   string
+}
+
+// Validate checks that the schema and table names are plain SQL identifiers
+func (t Table) Validate() error {
+	return db.ValidateTableName(t.Schema, t.Name)
+}
+
+// String returns the unquoted, schema-qualified table name as understood by gorm's Table()
+func (t Table) String() string {
+	if t.Schema == "" {
+		return t.Name
+	}
+	return t.Schema + "." + t.Name
+}
+
+// quoted returns the quoted, schema-qualified table name for raw SQL
+func (t Table) quoted() string {
+	return t.qualify(t.Name)
+}
+
+// qualify returns a quoted name for another relation living in the outbox table's schema
+func (t Table) qualify(name string) string {
+	return db.QuoteName(`"`, t.Schema, name)
+}
+
+// relayInstances returns the quoted name of the table tracking the relay instances sharding the outbox
+func (t Table) relayInstances() string {
+	return t.qualify(t.Name + "_relay_instances")
+}
+
+// partitionLeases returns the quoted name of the table leasing outbox partitions to relay instances
+func (t Table) partitionLeases() string {
+	return t.qualify(t.Name + "_partition_leases")
+}
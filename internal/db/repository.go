@@ -0,0 +1,34 @@
+// Package db defines the storage interface the outbox relay works against.
+// The postgres, mysql and sqlite packages implement it, sharing the migration runner of this package.
+package db
+
+import (
+	"time"
+
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+)
+
+type Repository interface {
+	// Methods to interact with the database
+	CreateOutboxMessage(message outbox.Message) error
+	CreateOutboxMessages(messages []outbox.Message) ([]uint, error)
+	FindUnprocessedMessages(batchSize int) ([]outbox.Message, error)
+	FindUnprocessedMessagesMatching(filter outbox.Filter, batchSize int) ([]outbox.Message, error)
+	FindTenantsWithPendingMessages(filter outbox.Filter) ([]string, error)
+	ClaimMessages(filter outbox.Filter, owner string, lease time.Duration, batchSize int) ([]outbox.Message, error)
+	ReleaseMessage(message outbox.Message, retryAt time.Time, lastError string) error
+	ReleaseExpiredLeases() (int64, error)
+	MarkMessageAsProcessed(message outbox.Message) error
+	MarkMessagesAsProcessed(ids []uint) error
+	MarkLeasedMessagesAsProcessed(owner string, ids []uint) (int64, error)
+	MarkMessageAsExpired(message outbox.Message) error
+	DeleteProcessedMessages(before time.Time) (int64, error)
+	FindMessagesToReencrypt(currentKeyID string, batchSize int) ([]outbox.Message, error)
+	UpdateMessageEncryption(message outbox.Message) error
+	UpdateMessagePayload(message outbox.Message) error
+	ListMessages(query outbox.Query) ([]outbox.Message, error)
+	MessageStats(query outbox.Query) ([]outbox.Stats, error)
+	BeginTransaction() Repository
+	RollBackTransaction() error
+	CommitTransaction() error
+}
@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultBusyTimeout is how long a writer waits for the write lock held by another connection
+const DefaultBusyTimeout = 5 * time.Second
+
+// Config holds the configuration for the SQLite database
+type Config struct {
+	// Optional existing DB instance. If provided, we will use it directly.
+	// It should be opened with a DSN built like BuildDSN's, in WAL mode with immediate transactions.
+	DBInstance *gorm.DB
+	// Path of the database file, created if it does not exist. In-memory databases are not
+	// supported: every pooled connection would see a database of its own.
+	Path string
+	// Optional time a writer waits for the write lock before failing with SQLITE_BUSY, defaults to DefaultBusyTimeout
+	BusyTimeout time.Duration
+	// Optional outbox table name, defaults to "messages". Use different names to run several outboxes in one database.
+	TableName string
+	// SkipAutoMigrate disables running the embedded migrations on startup.
+	// The schema version is then only verified, and migrations must be run out-of-band with Migrate.
+	SkipAutoMigrate bool
+}
+
+// Validate validates the provided database configuration
+func (c *Config) Validate() error {
+	if c.DBInstance == nil && c.Path == "" {
+		return fmt.Errorf("either DBInstance or Path must be provided")
+	}
+	if c.DBInstance == nil && (c.Path == ":memory:" || c.Path == "file::memory:") {
+		return fmt.Errorf("in-memory SQLite databases are not supported, use a file")
+	}
+	if c.BusyTimeout < 0 {
+		return fmt.Errorf("busy timeout must not be negative")
+	}
+	return c.Table().Validate()
+}
+
+// Table returns the outbox table configured for this database
+func (c *Config) Table() Table {
+	name := c.TableName
+	if name == "" {
+		name = DefaultTableName
+	}
+	return Table{Name: name}
+}
+
+// BuildDSN constructs the DSN of the database file. Readers never block the writer in WAL mode,
+// and transactions take the write lock when they begin, so that concurrent writers queue up for
+// the busy timeout instead of failing when a reading transaction tries to write.
+func (c *Config) BuildDSN() string {
+	timeout := c.BusyTimeout
+	if timeout == 0 {
+		timeout = DefaultBusyTimeout
+	}
+	params := url.Values{}
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", timeout.Milliseconds()))
+	params.Add("_pragma", "synchronous(NORMAL)")
+	// The driver writes times as "2006-01-02 15:04:05.999999999-07:00" by default. Setting _time_format
+	// would make it ignore _txlock.
+	params.Set("_txlock", "immediate")
+	return "file:" + c.Path + "?" + params.Encode()
+}
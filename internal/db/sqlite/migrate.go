@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"embed"
+
+	"github.com/outbox-go-sdk/internal/db"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// dialect runs the migrations in one transaction. It holds the database's write lock, so
+// concurrent runs wait for it and then find nothing to do.
+var dialect = db.Dialect{
+	Quote: `"`,
+	Serialize: func(gormDB *gorm.DB, _ string, fn func(conn *gorm.DB) error) error {
+		return gormDB.Transaction(fn)
+	},
+	MigrationsColumns: `table_name text     NOT NULL,
+		version    integer  NOT NULL,
+		applied_at datetime NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))`,
+	TableExists: func(gormDB *gorm.DB, _, name string) (bool, error) {
+		var exists bool
+		err := gormDB.Raw("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&exists).Error
+		return exists, err
+	},
+}
+
+// migrationData is passed to the migration templates
+type migrationData struct {
+	// Table is the quoted outbox table name
+	Table string
+	// Prefix is the bare table name, used to derive index names
+	Prefix string
+}
+
+// templateData returns the migration template data for the given outbox table
+func templateData(table Table) migrationData {
+	return migrationData{Table: table.quoted(), Prefix: table.Name}
+}
+
+// loadMigrations reads the embedded migrations ordered by version
+func loadMigrations() ([]db.Migration, error) {
+	return db.LoadMigrations(migrationFiles)
+}
+
+// LatestSchemaVersion returns the version of the newest embedded migration
+func LatestSchemaVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	return db.LatestVersion(migrations), nil
+}
+
+// Migrate applies all pending embedded migrations to the given outbox table.
+// It is safe to run concurrently from several processes.
+func Migrate(gormDB *gorm.DB, table Table) error {
+	if err := table.Validate(); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return db.Migrate(gormDB, dialect, "", table.Name, migrations, templateData(table))
+}
+
+// SchemaVersion returns the version of the last migration applied to the outbox table, or 0 if none
+func SchemaVersion(gormDB *gorm.DB, table Table) (int, error) {
+	return db.SchemaVersion(gormDB, dialect, "", table.Name)
+}
+
+// VerifySchema checks that the outbox table is at least at the schema version expected by this SDK.
+// A newer schema is accepted, so relays can keep running while a newer release rolls out.
+func VerifySchema(gormDB *gorm.DB, table Table) error {
+	required, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	return db.VerifySchema(gormDB, dialect, "", table.Name, required)
+}
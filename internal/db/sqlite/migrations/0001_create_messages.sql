@@ -0,0 +1,33 @@
+-- Outbox table with the columns of the PostgreSQL schema. Times are stored as UTC text in the
+-- driver's format, which sorts chronologically, so they can be compared as strings.
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+    id                integer PRIMARY KEY AUTOINCREMENT,
+    payload           blob,
+    -- Payloads are always stored as bytes in SQLite, the column only mirrors the PostgreSQL schema
+    payload_json      text,
+    content_type      text,
+    content_encoding  text,
+    event_type        text,
+    encryption_key_id text,
+    encrypted_key     blob,
+    headers           text,
+    status            text     NOT NULL DEFAULT 'pending',
+    deliver_after     datetime NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    tenant_id         text,
+    partition_key     text,
+    priority          integer  NOT NULL DEFAULT 0,
+    expires_at        datetime,
+    lease_owner       text,
+    lease_expires_at  datetime,
+    attempts          integer  NOT NULL DEFAULT 0,
+    last_error        text,
+    processed_at      datetime,
+    created_at        datetime,
+    updated_at        datetime
+);
+
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_pending_idx ON {{.Table}} (deliver_after, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_pending_priority_idx ON {{.Table}} (priority DESC, deliver_after, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_tenant_pending_idx ON {{.Table}} (tenant_id, deliver_after, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_tenant_status_idx ON {{.Table}} (tenant_id, status);
+CREATE INDEX IF NOT EXISTS {{.Prefix}}_in_flight_idx ON {{.Table}} (lease_expires_at) WHERE status = 'in_flight';
@@ -0,0 +1,456 @@
+package sqlite
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+
+	sqlitedriver "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// timeFormat is how the driver writes times, stored in UTC it sorts chronologically as text
+const timeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+func init() {
+	// SQLite has no hash function, partitions hash the partition key with CRC32 like the MySQL backend
+	sqlitedriver.MustRegisterDeterministicScalarFunction("outbox_crc32", 1, func(_ *sqlitedriver.FunctionContext, args []driver.Value) (driver.Value, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return nil, nil
+		case []byte:
+			return int64(crc32.ChecksumIEEE(v)), nil
+		default:
+			return int64(crc32.ChecksumIEEE([]byte(fmt.Sprint(v)))), nil
+		}
+	})
+}
+
+type gormRepository struct {
+	db    *gorm.DB
+	table Table
+}
+
+// NewGormRepository creates a repository storing the outbox in a SQLite database file
+func NewGormRepository(config *Config) (db.Repository, error) {
+	gormDB, err := Open(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bring the outbox schema up to date, or only check it when migrations are run out-of-band
+	table := config.Table()
+	if config.SkipAutoMigrate {
+		err = VerifySchema(gormDB, table)
+	} else {
+		err = Migrate(gormDB, table)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &gormRepository{db: gormDB, table: table}, nil
+}
+
+// Open returns the provided DB instance or opens the database file from the config
+func Open(config *Config) (*gorm.DB, error) {
+	// Validate the configuration
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Use provided DB instance or open the database file
+	if config.DBInstance != nil {
+		return config.DBInstance, nil
+	}
+	return gorm.Open(sqlite.Open(config.BuildDSN()), &gorm.Config{NowFunc: now})
+}
+
+// now returns the current time in UTC, the only time zone stored so that times compare as text
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// CreateOutboxMessage adds a new message to the outbox table
+func (r *gormRepository) CreateOutboxMessage(message outbox.Message) error {
+	message = storedMessage(message)
+	if err := r.db.Table(r.table.String()).Create(&message).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateOutboxMessages adds the messages to the outbox table in a single transaction and returns
+// their ids in the same order. SQLite does not accept DEFAULT in multi-row inserts, which gorm emits
+// when only some messages set an optional column, so the rows are inserted one by one.
+func (r *gormRepository) CreateOutboxMessages(messages []outbox.Message) ([]uint, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	rows := make([]outbox.Message, len(messages))
+	for i, message := range messages {
+		rows[i] = storedMessage(message)
+	}
+	if err := r.db.Transaction(func(tx *gorm.DB) error {
+		for i := range rows {
+			if err := tx.Table(r.table.String()).Create(&rows[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids, nil
+}
+
+// storedMessage returns the message as it is stored, with all its times in UTC
+func storedMessage(message outbox.Message) outbox.Message {
+	current := now()
+	if message.DeliverAfter.IsZero() {
+		message.DeliverAfter = current
+	}
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = current
+	}
+	if message.UpdatedAt.IsZero() {
+		message.UpdatedAt = current
+	}
+	message.DeliverAfter = message.DeliverAfter.UTC()
+	message.CreatedAt = message.CreatedAt.UTC()
+	message.UpdatedAt = message.UpdatedAt.UTC()
+	message.ExpiresAt = message.ExpiresAt.UTC()
+	return message
+}
+
+// BeginTransaction starts a new database transaction. It takes the database's write lock,
+// so relays and writers run their transactions one at a time.
+func (r *gormRepository) BeginTransaction() db.Repository {
+	return &gormRepository{
+		db:    r.db.Begin(),
+		table: r.table,
+	}
+}
+
+// FindUnprocessedMessages retrieves unprocessed outbox messages that are due, in batches.
+// SQLite has no row locks: the transaction holds the write lock until it ends instead.
+func (r *gormRepository) FindUnprocessedMessages(batchSize int) ([]outbox.Message, error) {
+	return r.FindUnprocessedMessagesMatching(outbox.Filter{}, batchSize)
+}
+
+// FindUnprocessedMessagesMatching retrieves unprocessed outbox messages that are due and match the filter.
+// Messages restricted to a priority range are returned highest priority first.
+func (r *gormRepository) FindUnprocessedMessagesMatching(filter outbox.Filter, batchSize int) ([]outbox.Message, error) {
+	var messages []outbox.Message
+	if err := r.pending(filter).Limit(batchSize).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ClaimMessages leases due messages matching the filter to the owner, moving them to the in-flight status
+// until the lease expires. The claim selects, updates and reads back the batch in a short transaction of
+// its own, or in a savepoint when the repository is in a transaction.
+// Messages are returned in the order FindUnprocessedMessagesMatching uses.
+func (r *gormRepository) ClaimMessages(filter outbox.Filter, owner string, lease time.Duration, batchSize int) ([]outbox.Message, error) {
+	var messages []outbox.Message
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		claim := &gormRepository{db: tx, table: r.table}
+
+		var ids []uint
+		if err := claim.pending(filter).Limit(batchSize).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if err := tx.Table(r.table.String()).Where("id IN ?", ids).UpdateColumns(map[string]interface{}{
+			"status":           outbox.StatusInFlight,
+			"lease_owner":      owner,
+			"lease_expires_at": now().Add(lease),
+			"attempts":         gorm.Expr("attempts + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Table(r.table.String()).Where("id IN ?", ids).Find(&messages).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading the rows back by id does not keep the order of the claim
+	sort.Slice(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if filter.Priority != nil && a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.DeliverAfter.Equal(b.DeliverAfter) {
+			return a.DeliverAfter.Before(b.DeliverAfter)
+		}
+		return a.ID < b.ID
+	})
+	return messages, nil
+}
+
+// ReleaseMessage returns an in-flight message that failed to publish to pending, due again at retryAt.
+// It does nothing if the lease was lost to the reaper in the meantime.
+func (r *gormRepository) ReleaseMessage(message outbox.Message, retryAt time.Time, lastError string) error {
+	if err := r.db.Table(r.table.String()).
+		Where("id = ? AND status = ? AND lease_owner = ?", message.ID, outbox.StatusInFlight, message.LeaseOwner).
+		UpdateColumns(map[string]interface{}{
+			"status":           outbox.StatusPending,
+			"lease_owner":      nil,
+			"lease_expires_at": nil,
+			"deliver_after":    retryAt.UTC(),
+			"last_error":       lastError,
+		}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReleaseExpiredLeases returns in-flight messages whose lease expired, e.g. after their worker crashed,
+// to pending so they are published again. It returns the number of released messages.
+func (r *gormRepository) ReleaseExpiredLeases() (int64, error) {
+	result := r.db.Table(r.table.String()).
+		Where("status = ? AND lease_expires_at < ?", outbox.StatusInFlight, now()).
+		UpdateColumns(map[string]interface{}{
+			"status":           outbox.StatusPending,
+			"lease_owner":      nil,
+			"lease_expires_at": nil,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// ListMessages returns the messages matching the query ordered by id, for admin tooling
+func (r *gormRepository) ListMessages(query outbox.Query) ([]outbox.Message, error) {
+	var messages []outbox.Message
+	db := r.matching(query).Where("id > ?", query.AfterID).Order("id")
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	if err := db.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MessageStats counts the messages matching the query by tenant and status
+func (r *gormRepository) MessageStats(query outbox.Query) ([]outbox.Stats, error) {
+	// Aggregates lose the column type, so the driver returns the oldest creation time as text
+	var rows []struct {
+		TenantID        string
+		Status          string
+		Count           int64
+		OldestCreatedAt string
+	}
+	if err := r.matching(query).
+		Select("COALESCE(tenant_id, '') AS tenant_id, status, count(*) AS count, COALESCE(min(created_at), '') AS oldest_created_at").
+		Group("COALESCE(tenant_id, ''), status").
+		Order("tenant_id, status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]outbox.Stats, len(rows))
+	for i, row := range rows {
+		stats[i] = outbox.Stats{TenantID: row.TenantID, Status: row.Status, Count: row.Count}
+		if row.OldestCreatedAt != "" {
+			oldest, err := time.Parse(timeFormat, row.OldestCreatedAt)
+			if err != nil {
+				return nil, err
+			}
+			stats[i].OldestCreatedAt = oldest
+		}
+	}
+	return stats, nil
+}
+
+// matching returns the query for the messages matching an admin query
+func (r *gormRepository) matching(query outbox.Query) *gorm.DB {
+	db := r.db.Table(r.table.String())
+	if query.TenantID != nil {
+		db = whereTenant(db, *query.TenantID)
+	}
+	if query.Status != "" {
+		db = db.Where("status = ?", query.Status)
+	}
+	return db
+}
+
+// pending returns the query for due pending messages matching the filter, in publishing order
+func (r *gormRepository) pending(filter outbox.Filter) *gorm.DB {
+	query := r.due(filter)
+	if filter.Priority != nil {
+		query = query.Order("priority DESC")
+	}
+	return query.Order("deliver_after, id")
+}
+
+// due returns the query for due pending messages matching the filter
+func (r *gormRepository) due(filter outbox.Filter) *gorm.DB {
+	query := r.db.Table(r.table.String()).
+		Where("status = ? AND deliver_after <= ?", outbox.StatusPending, now())
+	if filter.Partitions != nil {
+		query = query.Where("outbox_crc32(COALESCE(partition_key, CAST(id AS TEXT))) % ? IN ?",
+			filter.Partitions.Count, partitionIDs(filter.Partitions.IDs))
+	}
+	if filter.TenantID != nil {
+		query = whereTenant(query, *filter.TenantID)
+	}
+	if filter.Priority != nil {
+		query = query.Where("priority BETWEEN ? AND ?", filter.Priority.Min, filter.Priority.Max)
+	}
+	return query
+}
+
+// partitionIDs returns the partitions for an IN list, keeping an empty set from matching anything
+func partitionIDs(ids []int) []int {
+	if len(ids) == 0 {
+		// No partition has a negative id
+		return []int{-1}
+	}
+	return ids
+}
+
+// whereTenant restricts the query to a tenant, the empty tenant matching messages without one
+func whereTenant(query *gorm.DB, tenantID string) *gorm.DB {
+	if tenantID == "" {
+		return query.Where("tenant_id IS NULL")
+	}
+	return query.Where("tenant_id = ?", tenantID)
+}
+
+// FindTenantsWithPendingMessages returns the tenants with due messages matching the filter, sorted,
+// the empty tenant standing for messages without one
+func (r *gormRepository) FindTenantsWithPendingMessages(filter outbox.Filter) ([]string, error) {
+	var tenants []string
+	if err := r.due(filter).
+		Distinct().
+		Pluck("COALESCE(tenant_id, '')", &tenants).Error; err != nil {
+		return nil, err
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// MarkMessageAsProcessed marks a message as processed in the database
+func (r *gormRepository) MarkMessageAsProcessed(message outbox.Message) error {
+	if err := r.db.Table(r.table.String()).Where("id = ?", message.ID).UpdateColumns(map[string]interface{}{
+		"status":       outbox.StatusProcessed,
+		"processed_at": now(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarkMessagesAsProcessed marks all messages with the given ids as processed in a single UPDATE
+func (r *gormRepository) MarkMessagesAsProcessed(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := r.db.Table(r.table.String()).Where("id IN ?", ids).UpdateColumns(map[string]interface{}{
+		"status":       outbox.StatusProcessed,
+		"processed_at": now(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarkLeasedMessagesAsProcessed marks the messages with the given ids still leased to the owner as processed
+// in a single UPDATE, leaving alone those whose lease was lost to the reaper or another relay. It returns the
+// number of messages marked.
+func (r *gormRepository) MarkLeasedMessagesAsProcessed(owner string, ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := r.db.Table(r.table.String()).
+		Where("id IN ? AND status = ? AND lease_owner = ?", ids, outbox.StatusInFlight, owner).
+		UpdateColumns(map[string]interface{}{
+			"status":       outbox.StatusProcessed,
+			"processed_at": now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// MarkMessageAsExpired moves a message that expired before being published to the expired status
+func (r *gormRepository) MarkMessageAsExpired(message outbox.Message) error {
+	if err := r.db.Table(r.table.String()).Where("id = ?", message.ID).UpdateColumns(map[string]interface{}{
+		"status": outbox.StatusExpired,
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteProcessedMessages deletes the messages processed, or expired, before the given time to keep the
+// outbox table from growing forever. Pending and in-flight messages are never deleted. It returns the
+// number of deleted messages.
+func (r *gormRepository) DeleteProcessedMessages(before time.Time) (int64, error) {
+	before = before.UTC()
+	result := r.db.Table(r.table.String()).
+		Where("(status = ? AND processed_at < ?) OR (status = ? AND expires_at < ?)",
+			outbox.StatusProcessed, before, outbox.StatusExpired, before).
+		Delete(&outbox.Message{})
+	return result.RowsAffected, result.Error
+}
+
+// FindMessagesToReencrypt retrieves messages encrypted with a key other than the current one
+func (r *gormRepository) FindMessagesToReencrypt(currentKeyID string, batchSize int) ([]outbox.Message, error) {
+	var messages []outbox.Message
+	if err := r.db.Table(r.table.String()).
+		Select("id", "encryption_key_id", "encrypted_key").
+		Where("encryption_key_id IS NOT NULL AND encryption_key_id <> '' AND encryption_key_id <> ?", currentKeyID).
+		Order("id").
+		Limit(batchSize).
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// UpdateMessageEncryption stores a rewrapped data key and its key id
+func (r *gormRepository) UpdateMessageEncryption(message outbox.Message) error {
+	if err := r.db.Table(r.table.String()).Where("id = ?", message.ID).UpdateColumns(map[string]interface{}{
+		"encryption_key_id": message.EncryptionKeyID,
+		"encrypted_key":     message.EncryptedKey,
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateMessagePayload stores the encrypted payload of a message inserted before its id was known,
+// together with its wrapped data key and key id
+func (r *gormRepository) UpdateMessagePayload(message outbox.Message) error {
+	if err := r.db.Table(r.table.String()).Where("id = ?", message.ID).UpdateColumns(map[string]interface{}{
+		"payload":           message.Payload,
+		"encryption_key_id": message.EncryptionKeyID,
+		"encrypted_key":     message.EncryptedKey,
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *gormRepository) CommitTransaction() error {
+	return r.db.Commit().Error
+}
+
+func (r *gormRepository) RollBackTransaction() error {
+	return r.db.Rollback().Error
+}
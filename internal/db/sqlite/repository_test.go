@@ -0,0 +1,135 @@
+package sqlite
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/outboxtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRepository(t *testing.T, config *Config) db.Repository {
+	if config.Path == "" && config.DBInstance == nil {
+		config.Path = filepath.Join(t.TempDir(), "outbox.db")
+	}
+	repo, err := NewGormRepository(config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB, _ := repo.(*gormRepository).db.DB()
+		sqlDB.Close()
+	})
+	return repo
+}
+
+func TestRepositoryContract(t *testing.T) {
+	outboxtest.RunRepositoryContract(t, func(t *testing.T) db.Repository {
+		return newTestRepository(t, &Config{})
+	}, outboxtest.WithoutRowLocks())
+}
+
+func TestRepository_ConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	relay := newTestRepository(t, &Config{Path: path})
+
+	// Several processes enqueue through connections of their own while the relay claims and publishes
+	const writers, perWriter = 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writer := newTestRepository(t, &Config{Path: path})
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				tx := writer.BeginTransaction()
+				if !assert.NoError(t, tx.CreateOutboxMessage(outbox.Message{Payload: []byte(fmt.Sprintf("%d-%d", w, i))})) {
+					tx.RollBackTransaction()
+					return
+				}
+				assert.NoError(t, tx.CommitTransaction())
+			}
+		}(w)
+	}
+
+	published := map[uint]int{}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for finished := false; !finished; {
+		// Poll like the relay does, rather than queueing for the write lock in a busy loop
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(10 * time.Millisecond):
+		}
+		for {
+			claimed, err := relay.ClaimMessages(outbox.Filter{}, "relay", time.Minute, 20)
+			require.NoError(t, err)
+			if len(claimed) == 0 {
+				break
+			}
+			ids := make([]uint, len(claimed))
+			for i, message := range claimed {
+				published[message.ID]++
+				ids[i] = message.ID
+			}
+			require.NoError(t, relay.MarkMessagesAsProcessed(ids))
+		}
+	}
+
+	assert.Len(t, published, writers*perWriter)
+	for id, count := range published {
+		assert.Equal(t, 1, count, "message %d published more than once", id)
+	}
+}
+
+func TestRepository_WALMode(t *testing.T) {
+	repo := newTestRepository(t, &Config{})
+
+	var mode string
+	require.NoError(t, repo.(*gormRepository).db.Raw("PRAGMA journal_mode").Scan(&mode).Error)
+	assert.Equal(t, "wal", mode)
+}
+
+func TestConfig_Validate_Failure(t *testing.T) {
+	assert.Error(t, (&Config{}).Validate())
+	assert.Error(t, (&Config{Path: ":memory:"}).Validate())
+	assert.Error(t, (&Config{Path: "outbox.db", BusyTimeout: -time.Second}).Validate())
+	assert.Error(t, (&Config{Path: "outbox.db", TableName: "events; DROP TABLE users"}).Validate())
+}
+
+func TestMigrate_SkipAutoMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+
+	_, err := NewGormRepository(&Config{Path: path, SkipAutoMigrate: true})
+	assert.ErrorContains(t, err, "run the outbox migrations")
+
+	newTestRepository(t, &Config{Path: path, TableName: "events"})
+	newTestRepository(t, &Config{Path: path, TableName: "events", SkipAutoMigrate: true})
+}
+
+func TestVerifySchema_NewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	repo := newTestRepository(t, &Config{Path: path})
+	gormDB := repo.(*gormRepository).db
+	table := (&Config{}).Table()
+	latest, err := LatestSchemaVersion()
+	require.NoError(t, err)
+
+	// A newer release migrated the table further while this one is still running
+	require.NoError(t, gormDB.Exec(fmt.Sprintf("INSERT INTO %q (table_name, version) VALUES (?, ?)", db.MigrationsTable),
+		table.Name, latest+1).Error)
+	assert.NoError(t, VerifySchema(gormDB, table))
+
+	// An older schema still has to be migrated
+	require.NoError(t, gormDB.Exec(fmt.Sprintf("DELETE FROM %q WHERE version >= ?", db.MigrationsTable), latest).Error)
+	assert.ErrorContains(t, VerifySchema(gormDB, table), "expected at least")
+}
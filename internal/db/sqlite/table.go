@@ -0,0 +1,26 @@
+package sqlite
+
+import "github.com/outbox-go-sdk/internal/db"
+
+// DefaultTableName is the outbox table used when no table name is configured
+const DefaultTableName = "messages"
+
+// Table identifies the outbox table in the database file
+type Table struct {
+	Name string
+}
+
+// Validate checks that the table name is a plain SQL identifier
+func (t Table) Validate() error {
+	return db.ValidateTableName("", t.Name)
+}
+
+// String returns the unquoted table name as understood by gorm's Table()
+func (t Table) String() string {
+	return t.Name
+}
+
+// quoted returns the quoted table name for raw SQL
+func (t Table) quoted() string {
+	return db.QuoteName(`"`, "", t.Name)
+}
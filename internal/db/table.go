@@ -0,0 +1,28 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateTableName checks that the table name, and the schema when set, are plain SQL identifiers
+func ValidateTableName(schema, name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("invalid outbox table name %q", name)
+	}
+	if schema != "" && !identifierPattern.MatchString(schema) {
+		return fmt.Errorf("invalid outbox schema name %q", schema)
+	}
+	return nil
+}
+
+// QuoteName returns the name, qualified by the schema when set, quoted with the dialect's identifier
+// quote for raw SQL. Both must have passed ValidateTableName.
+func QuoteName(quote, schema, name string) string {
+	if schema == "" {
+		return quote + name + quote
+	}
+	return quote + schema + quote + "." + quote + name + quote
+}
@@ -0,0 +1,18 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteName(t *testing.T) {
+	assert.Equal(t, `"messages"`, QuoteName(`"`, "", "messages"))
+	assert.Equal(t, "`billing`.`events`", QuoteName("`", "billing", "events"))
+}
+
+func TestValidateTableName_Failure(t *testing.T) {
+	assert.NoError(t, ValidateTableName("billing", "events"))
+	assert.Error(t, ValidateTableName("", "events; DROP TABLE users"))
+	assert.Error(t, ValidateTableName("bill-ing", "events"))
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import "time"
+
+// Filter restricts which pending messages a repository returns. The zero Filter matches all messages.
+type Filter struct {
+	// Priority restricts messages to a priority range when set
+	Priority *PriorityRange
+	// Partitions restricts messages to the given partitions when set
+	Partitions *PartitionSet
+	// TenantID restricts messages to a tenant when set, the empty tenant matches messages without one
+	TenantID *string
+}
+
+// PriorityRange is an inclusive range of message priorities
+type PriorityRange struct {
+	Min int
+	Max int
+}
+
+// Contains reports whether the priority lies within the range
+func (r PriorityRange) Contains(priority int) bool {
+	return priority >= r.Min && priority <= r.Max
+}
+
+// PartitionSet is a set of the partitions the outbox is split into by hash of the partition key.
+// Messages without a partition key are spread across the partitions by id.
+type PartitionSet struct {
+	// Count is the total number of partitions
+	Count int
+	// IDs are the partitions in the set, from 0 to Count-1
+	IDs []int
+}
+
+// Query selects messages for admin and statistics queries. The zero Query matches all messages.
+type Query struct {
+	// TenantID restricts the query to a tenant when set, the empty tenant matches messages without one
+	TenantID *string
+	// Status restricts the query to a status when set
+	Status string
+	// AfterID and Limit page through listed messages by id, a zero Limit returns all of them
+	AfterID uint
+	Limit   int
+}
+
+// Stats counts the messages of a tenant in a status
+type Stats struct {
+	TenantID string
+	Status   string
+	Count    int64
+	// OldestCreatedAt is the creation time of the oldest message counted
+	OldestCreatedAt time.Time
+}
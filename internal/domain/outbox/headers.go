@@ -0,0 +1,33 @@
+package outbox
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Headers are key/value pairs stored with a message and forwarded as NATS headers when it is published
+type Headers map[string]string
+
+// Value stores the headers as a JSON object, or NULL when empty
+func (h Headers) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(h)
+}
+
+// Scan reads headers stored as a JSON object
+func (h *Headers) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, h)
+	case string:
+		return json.Unmarshal([]byte(v), h)
+	default:
+		return fmt.Errorf("cannot scan %T into outbox.Headers", src)
+	}
+}
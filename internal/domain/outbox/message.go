@@ -1,13 +1,97 @@
 package outbox
 
-import "time"
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Message statuses
+const (
+	// StatusPending messages are waiting to be published
+	StatusPending = "pending"
+	// StatusInFlight messages are leased to a relay worker publishing them
+	StatusInFlight = "in_flight"
+	// StatusProcessed messages have been published
+	StatusProcessed = "processed"
+	// StatusExpired messages passed their ExpiresAt before they could be published
+	StatusExpired = "expired"
+)
 
 // Message represents the message structure in the outbox table
 type Message struct {
-	ID          uint      `gorm:"primaryKey"`
-	Payload     string    `gorm:"type:text"`
-	Status      string    `gorm:"type:varchar(50);default:'pending'"`
+	ID      uint   `gorm:"primaryKey"`
+	Payload []byte `gorm:"type:bytea"`
+	// PayloadJSON holds the payload when the repository stores JSON as jsonb.
+	// It is a storage detail: repositories always return the payload in Payload.
+	PayloadJSON []byte `gorm:"column:payload_json;type:jsonb"`
+	// ContentType describes the payload encoding, e.g. "application/json". It is forwarded as a header.
+	ContentType string `gorm:"type:varchar(255)"`
+	// ContentEncoding names the compression applied to the payload, e.g. "gzip". Empty means uncompressed.
+	ContentEncoding string `gorm:"type:varchar(32)"`
+	// EventType optionally names the kind of event, e.g. "com.example.order.created"
+	EventType string `gorm:"type:varchar(255)"`
+	// EncryptionKeyID names the key-encryption key wrapping EncryptedKey when the payload is encrypted
+	EncryptionKeyID string `gorm:"type:varchar(255)"`
+	// EncryptedKey is the wrapped data key the payload is encrypted with
+	EncryptedKey []byte `gorm:"type:bytea"`
+	// Headers are forwarded as NATS headers when the message is published
+	Headers Headers `gorm:"type:jsonb"`
+	Status  string  `gorm:"type:varchar(50);default:'pending'"`
+	// DeliverAfter delays publication until the given time. Zero means as soon as possible.
+	DeliverAfter time.Time `gorm:"default:now()"`
+	// TenantID isolates the messages of a tenant, it is limited to letters, digits, '-' and '_'
+	TenantID string `gorm:"default:null"`
+	// PartitionKey keeps messages with the same key in one partition, published in order when the relays are sharded
+	PartitionKey string `gorm:"default:null"`
+	// Priority orders messages across priority lanes, higher is served first
+	Priority int `gorm:"type:smallint;not null;default:0"`
+	// ExpiresAt optionally discards the message if it has not been published by then
+	ExpiresAt time.Time `gorm:"default:null"`
+	// LeaseOwner and LeaseExpiresAt record the relay worker publishing an in-flight message and until when
+	LeaseOwner     string    `gorm:"default:null"`
+	LeaseExpiresAt time.Time `gorm:"default:null"`
+	// Attempts counts the times the message was claimed for publishing, LastError holds the last failure
+	Attempts    int       `gorm:"not null;default:0"`
+	LastError   string    `gorm:"default:null"`
 	ProcessedAt time.Time `gorm:"default:null"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
+
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidateTenantID checks that a tenant id can be used as a token of a NATS subject
+func ValidateTenantID(tenantID string) error {
+	if tenantID != "" && !tenantIDPattern.MatchString(tenantID) {
+		return fmt.Errorf("invalid tenant id %q: only letters, digits, '-' and '_' are allowed", tenantID)
+	}
+	return nil
+}
+
+// IsEncrypted reports whether the payload is stored encrypted
+func (m Message) IsEncrypted() bool {
+	return m.EncryptionKeyID != ""
+}
+
+// IsExpired reports whether the message has an expiry that has passed at the given time
+func (m Message) IsExpired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
+}
+
+// IsCompressed reports whether the payload is stored compressed
+func (m Message) IsCompressed() bool {
+	return m.ContentEncoding != ""
+}
+
+// IsJSONContentType reports whether the content type denotes a JSON document,
+// e.g. "application/json" or "application/cloudevents+json; charset=utf-8"
+func IsJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
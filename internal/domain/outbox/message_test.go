@@ -0,0 +1,28 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsJSONContentType(t *testing.T) {
+	assert.True(t, IsJSONContentType("application/json"))
+	assert.True(t, IsJSONContentType("application/json; charset=utf-8"))
+	assert.True(t, IsJSONContentType("application/cloudevents+json"))
+	assert.False(t, IsJSONContentType("application/x-protobuf"))
+	assert.False(t, IsJSONContentType(""))
+}
+
+func TestHeaders_ValueAndScan(t *testing.T) {
+	value, err := Headers{"Outbox-Codec": "json"}.Value()
+	assert.NoError(t, err)
+
+	var headers Headers
+	assert.NoError(t, headers.Scan(value))
+	assert.Equal(t, Headers{"Outbox-Codec": "json"}, headers)
+
+	value, err = Headers{}.Value()
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
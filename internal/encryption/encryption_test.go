@@ -0,0 +1,93 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": oldKey})
+	require.NoError(t, err)
+	encryptor := NewEncryptor(keys)
+
+	envelope, err := encryptor.Encrypt(7, []byte("card=4111"))
+	require.NoError(t, err)
+	assert.Equal(t, "k1", envelope.KeyID)
+	assert.NotContains(t, string(envelope.Ciphertext), "4111")
+
+	plaintext, err := encryptor.Decrypt(7, envelope)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("card=4111"), plaintext)
+}
+
+func TestRewrap(t *testing.T) {
+	oldKeys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": oldKey})
+	require.NoError(t, err)
+	envelope, err := NewEncryptor(oldKeys).Encrypt(7, []byte("payload"))
+	require.NoError(t, err)
+
+	rotated, err := NewStaticKeyProvider("k2", map[string][]byte{"k1": oldKey, "k2": newKey})
+	require.NoError(t, err)
+	rewrapped, err := NewEncryptor(rotated).Rewrap(envelope)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", rewrapped.KeyID)
+	assert.Equal(t, envelope.Ciphertext, rewrapped.Ciphertext)
+
+	// The old key is no longer needed once rewrapped
+	newOnly, err := NewStaticKeyProvider("k2", map[string][]byte{"k2": newKey})
+	require.NoError(t, err)
+	plaintext, err := NewEncryptor(newOnly).Decrypt(7, rewrapped)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), plaintext)
+}
+
+func TestDecrypt_Failure_TamperedKeyID(t *testing.T) {
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": oldKey, "k2": oldKey})
+	require.NoError(t, err)
+	encryptor := NewEncryptor(keys)
+
+	envelope, err := encryptor.Encrypt(7, []byte("payload"))
+	require.NoError(t, err)
+	envelope.KeyID = "k2"
+
+	_, err = encryptor.Decrypt(7, envelope)
+	assert.Error(t, err)
+}
+
+func TestDecrypt_Failure_MovedToAnotherMessage(t *testing.T) {
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": oldKey})
+	require.NoError(t, err)
+	encryptor := NewEncryptor(keys)
+
+	envelope, err := encryptor.Encrypt(7, []byte("payload"))
+	require.NoError(t, err)
+
+	_, err = encryptor.Decrypt(8, envelope)
+	assert.Error(t, err)
+}
+
+func TestNewStaticKeyProvider_Failure(t *testing.T) {
+	_, err := NewStaticKeyProvider("k1", map[string][]byte{"k2": newKey})
+	assert.Error(t, err)
+
+	_, err = NewStaticKeyProvider("k1", map[string][]byte{"k1": []byte("short")})
+	assert.Error(t, err)
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("k1:" + base64.StdEncoding.EncodeToString(oldKey) + ", k2:" + base64.StdEncoding.EncodeToString(newKey))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"k1": oldKey, "k2": newKey}, keys)
+
+	_, err = ParseKeys("k1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+// dataKeySize is the size of the per-message AES-256 data keys
+const dataKeySize = 32
+
+// Envelope is an encrypted payload together with its wrapped data key
+type Envelope struct {
+	Ciphertext []byte
+	// WrappedKey is the data key encrypted with the key-encryption key KeyID
+	WrappedKey []byte
+	KeyID      string
+}
+
+// Encryptor performs AES-GCM envelope encryption of message payloads
+type Encryptor struct {
+	keys KeyProvider
+}
+
+// NewEncryptor creates an Encryptor using the provided key-encryption keys
+func NewEncryptor(keys KeyProvider) *Encryptor {
+	return &Encryptor{keys: keys}
+}
+
+// Encrypt encrypts the payload of the message with the given id with a fresh data key wrapped by the
+// current key. The ciphertext is bound to the message id, so it cannot be moved to another row.
+func (e *Encryptor) Encrypt(messageID uint, plaintext []byte) (Envelope, error) {
+	keyID, kek, err := e.keys.CurrentKey()
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return Envelope{}, err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext, messageData(messageID))
+	if err != nil {
+		return Envelope{}, err
+	}
+	// The key id is authenticated so a wrapped key cannot be relabelled
+	wrappedKey, err := seal(kek, dataKey, []byte(keyID))
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Ciphertext: ciphertext, WrappedKey: wrappedKey, KeyID: keyID}, nil
+}
+
+// Decrypt unwraps the data key and decrypts the payload of the message with the given id
+func (e *Encryptor) Decrypt(messageID uint, envelope Envelope) ([]byte, error) {
+	dataKey, err := e.unwrap(envelope.WrappedKey, envelope.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(dataKey, envelope.Ciphertext, messageData(messageID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting payload: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Rewrap re-encrypts the data key of an envelope with the current key.
+// The payload ciphertext does not change, so rotating keys only rewrites the small wrapped key:
+// the ciphertext is bound to the message id alone, not to the key id.
+func (e *Encryptor) Rewrap(envelope Envelope) (Envelope, error) {
+	dataKey, err := e.unwrap(envelope.WrappedKey, envelope.KeyID)
+	if err != nil {
+		return Envelope{}, err
+	}
+	keyID, kek, err := e.keys.CurrentKey()
+	if err != nil {
+		return Envelope{}, err
+	}
+	wrappedKey, err := seal(kek, dataKey, []byte(keyID))
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Ciphertext: envelope.Ciphertext, WrappedKey: wrappedKey, KeyID: keyID}, nil
+}
+
+// CurrentKeyID returns the id of the key new messages are encrypted with
+func (e *Encryptor) CurrentKeyID() (string, error) {
+	keyID, _, err := e.keys.CurrentKey()
+	return keyID, err
+}
+
+func (e *Encryptor) unwrap(wrappedKey []byte, keyID string) ([]byte, error) {
+	kek, err := e.keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(kek, wrappedKey, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key with key %q: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// messageData returns the additional data binding a payload ciphertext to its message id
+func messageData(messageID uint) []byte {
+	return binary.BigEndian.AppendUint64([]byte("outbox-message:"), uint64(messageID))
+}
+
+// seal encrypts with AES-GCM and prepends the random nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts data produced by seal
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,73 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// KeyProvider supplies the key-encryption keys used to wrap the per-message data keys
+type KeyProvider interface {
+	// CurrentKey returns the id and key used to encrypt new messages
+	CurrentKey() (keyID string, key []byte, err error)
+	// Key returns the key with the given id, used to decrypt existing messages
+	Key(keyID string) ([]byte, error)
+}
+
+// staticKeyProvider serves keys from memory
+type staticKeyProvider struct {
+	currentKeyID string
+	keys         map[string][]byte
+}
+
+// NewStaticKeyProvider creates a KeyProvider over a fixed set of AES keys (16, 24 or 32 bytes).
+// Keep retired keys in the set until all messages have been re-encrypted with the current one.
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) (KeyProvider, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("current encryption key %q is not in the key set", currentKeyID)
+	}
+	for id, key := range keys {
+		if id == "" {
+			return nil, fmt.Errorf("encryption key ids must not be empty")
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("encryption key %q must be 16, 24 or 32 bytes, got %d", id, len(key))
+		}
+	}
+	return &staticKeyProvider{currentKeyID: currentKeyID, keys: keys}, nil
+}
+
+func (p *staticKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.currentKeyID, p.keys[p.currentKeyID], nil
+}
+
+func (p *staticKeyProvider) Key(keyID string) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	return key, nil
+}
+
+// ParseKeys parses a key set written as "id1:base64key1,id2:base64key2", e.g. from an environment variable
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("encryption key entry must be <id>:<base64 key>")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q is not valid base64: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
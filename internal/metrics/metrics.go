@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"expvar"
+	"sync/atomic"
+)
+
+// Recorder receives the outcome of processed outbox messages
+type Recorder interface {
+	IncPublished(n int)
+	IncFailed(n int)
+	IncExpired(n int)
+}
+
+// Noop discards all metrics
+type Noop struct{}
+
+func (Noop) IncPublished(int) {}
+func (Noop) IncFailed(int)    {}
+func (Noop) IncExpired(int)   {}
+
+// Counters keeps running totals in memory
+type Counters struct {
+	published atomic.Int64
+	failed    atomic.Int64
+	expired   atomic.Int64
+}
+
+// NewCounters creates a Recorder keeping running totals
+func NewCounters() *Counters {
+	return &Counters{}
+}
+
+func (c *Counters) IncPublished(n int) {
+	c.published.Add(int64(n))
+}
+
+func (c *Counters) IncFailed(n int) {
+	c.failed.Add(int64(n))
+}
+
+func (c *Counters) IncExpired(n int) {
+	c.expired.Add(int64(n))
+}
+
+// Published returns the number of messages published
+func (c *Counters) Published() int64 {
+	return c.published.Load()
+}
+
+// Failed returns the number of failed publish attempts
+func (c *Counters) Failed() int64 {
+	return c.failed.Load()
+}
+
+// Expired returns the number of messages that expired before they could be published
+func (c *Counters) Expired() int64 {
+	return c.expired.Load()
+}
+
+// Snapshot returns all counters by name
+func (c *Counters) Snapshot() map[string]int64 {
+	return map[string]int64{
+		"published": c.Published(),
+		"failed":    c.Failed(),
+		"expired":   c.Expired(),
+	}
+}
+
+// Publish exposes the counters as an expvar variable, served under /debug/vars by expvar.Handler
+func (c *Counters) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Snapshot()
+	}))
+}
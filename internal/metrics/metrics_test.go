@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters(t *testing.T) {
+	counters := NewCounters()
+	counters.IncPublished(3)
+	counters.IncFailed(1)
+	counters.IncExpired(2)
+	counters.IncPublished(1)
+
+	assert.Equal(t, map[string]int64{"published": 4, "failed": 1, "expired": 2}, counters.Snapshot())
+
+	counters.Publish("outbox_test")
+	assert.JSONEq(t, `{"published": 4, "failed": 1, "expired": 2}`, expvar.Get("outbox_test").String())
+}
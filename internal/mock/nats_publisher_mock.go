@@ -1,6 +1,8 @@
 package mock
 
 import (
+	"github.com/outbox-go-sdk/internal/publisher/nats"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -14,6 +16,26 @@ func (m *PublisherMock) PublishMessage(subject string, payload []byte) error {
 	return args.Error(0)
 }
 
+func (m *PublisherMock) PublishMessageWithHeaders(subject string, payload []byte, headers map[string]string) error {
+	args := m.Called(subject, payload, headers)
+	return args.Error(0)
+}
+
+func (m *PublisherMock) PublishBatch(messages []nats.Message) []error {
+	args := m.Called(messages)
+	return args.Get(0).([]error)
+}
+
+func (m *PublisherMock) Flush() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *PublisherMock) MaxPayload() int64 {
+	args := m.Called()
+	return args.Get(0).(int64)
+}
+
 func (m *PublisherMock) Close() {
 	m.Called()
 }
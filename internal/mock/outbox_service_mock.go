@@ -1,6 +1,10 @@
 package mock
 
-import "github.com/stretchr/testify/mock"
+import (
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+
+	"github.com/stretchr/testify/mock"
+)
 
 // OutboxServiceMock Mocking the Service layer
 type OutboxServiceMock struct {
@@ -12,6 +16,17 @@ func (m *OutboxServiceMock) CreateOutboxMessage(payload string) error {
 	return args.Error(0)
 }
 
+func (m *OutboxServiceMock) EnqueueMessage(message outbox.Message) error {
+	args := m.Called(message)
+	return args.Error(0)
+}
+
+func (m *OutboxServiceMock) EnqueueMessages(messages []outbox.Message) ([]uint, error) {
+	args := m.Called(messages)
+	ids, _ := args.Get(0).([]uint)
+	return ids, args.Error(1)
+}
+
 func (m *OutboxServiceMock) ProcessOutboxMessages() error {
 	args := m.Called()
 	return args.Error(0)
@@ -1,7 +1,9 @@
 package mock
 
 import (
-	"github.com/outbox-go-sdk/internal/db/postgres"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/db"
 	"github.com/outbox-go-sdk/internal/domain/outbox"
 
 	"github.com/stretchr/testify/mock"
@@ -12,9 +14,9 @@ type DBRepoMock struct {
 	mock.Mock
 }
 
-func (m *DBRepoMock) BeginTransaction() postgres.Repository {
+func (m *DBRepoMock) BeginTransaction() db.Repository {
 	args := m.Called()
-	return args.Get(0).(postgres.Repository)
+	return args.Get(0).(db.Repository)
 }
 
 func (m *DBRepoMock) CreateOutboxMessage(message outbox.Message) error {
@@ -22,16 +24,92 @@ func (m *DBRepoMock) CreateOutboxMessage(message outbox.Message) error {
 	return args.Error(0)
 }
 
+func (m *DBRepoMock) CreateOutboxMessages(messages []outbox.Message) ([]uint, error) {
+	args := m.Called(messages)
+	ids, _ := args.Get(0).([]uint)
+	return ids, args.Error(1)
+}
+
 func (m *DBRepoMock) FindUnprocessedMessages(batchSize int) ([]outbox.Message, error) {
 	args := m.Called(batchSize)
 	return args.Get(0).([]outbox.Message), args.Error(1)
 }
 
+func (m *DBRepoMock) FindUnprocessedMessagesMatching(filter outbox.Filter, batchSize int) ([]outbox.Message, error) {
+	args := m.Called(filter, batchSize)
+	return args.Get(0).([]outbox.Message), args.Error(1)
+}
+
+func (m *DBRepoMock) FindTenantsWithPendingMessages(filter outbox.Filter) ([]string, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *DBRepoMock) ListMessages(query outbox.Query) ([]outbox.Message, error) {
+	args := m.Called(query)
+	return args.Get(0).([]outbox.Message), args.Error(1)
+}
+
+func (m *DBRepoMock) MessageStats(query outbox.Query) ([]outbox.Stats, error) {
+	args := m.Called(query)
+	return args.Get(0).([]outbox.Stats), args.Error(1)
+}
+
+func (m *DBRepoMock) ClaimMessages(filter outbox.Filter, owner string, lease time.Duration, batchSize int) ([]outbox.Message, error) {
+	args := m.Called(filter, owner, lease, batchSize)
+	return args.Get(0).([]outbox.Message), args.Error(1)
+}
+
+func (m *DBRepoMock) ReleaseMessage(message outbox.Message, retryAt time.Time, lastError string) error {
+	args := m.Called(message, retryAt, lastError)
+	return args.Error(0)
+}
+
+func (m *DBRepoMock) ReleaseExpiredLeases() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (m *DBRepoMock) MarkMessageAsProcessed(message outbox.Message) error {
 	args := m.Called(message)
 	return args.Error(0)
 }
 
+func (m *DBRepoMock) MarkMessagesAsProcessed(ids []uint) error {
+	args := m.Called(ids)
+	return args.Error(0)
+}
+
+func (m *DBRepoMock) MarkLeasedMessagesAsProcessed(owner string, ids []uint) (int64, error) {
+	args := m.Called(owner, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *DBRepoMock) MarkMessageAsExpired(message outbox.Message) error {
+	args := m.Called(message)
+	return args.Error(0)
+}
+
+func (m *DBRepoMock) DeleteProcessedMessages(before time.Time) (int64, error) {
+	args := m.Called(before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *DBRepoMock) FindMessagesToReencrypt(currentKeyID string, batchSize int) ([]outbox.Message, error) {
+	args := m.Called(currentKeyID, batchSize)
+	return args.Get(0).([]outbox.Message), args.Error(1)
+}
+
+func (m *DBRepoMock) UpdateMessageEncryption(message outbox.Message) error {
+	args := m.Called(message)
+	return args.Error(0)
+}
+
+func (m *DBRepoMock) UpdateMessagePayload(message outbox.Message) error {
+	args := m.Called(message)
+	return args.Error(0)
+}
+
 func (m *DBRepoMock) CommitTransaction() error {
 	args := m.Called()
 	return args.Error(0)
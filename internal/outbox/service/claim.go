@@ -0,0 +1,114 @@
+package service
+
+import (
+	db "github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+)
+
+// claimFunc claims up to limit due messages matching the filter
+type claimFunc func(filter outbox.Filter, limit int) ([]outbox.Message, error)
+
+// findMessages locks the next batch of messages in the transaction of dbRepo
+func (s *service) findMessages(dbRepo db.Repository) ([]outbox.Message, error) {
+	return s.claimBatch(dbRepo, func(filter outbox.Filter, limit int) ([]outbox.Message, error) {
+		if filter == (outbox.Filter{}) {
+			return dbRepo.FindUnprocessedMessages(limit)
+		}
+		return dbRepo.FindUnprocessedMessagesMatching(filter, limit)
+	}, true)
+}
+
+// claimBatch claims the next batch of messages from the relay's partitions when sharded,
+// split across the priority lanes and the tenants when configured.
+// Sticky claims, i.e. row locks, return the messages already claimed again on the next call.
+func (s *service) claimBatch(dbRepo db.Repository, claim claimFunc, sticky bool) ([]outbox.Message, error) {
+	var filter outbox.Filter
+	if s.partitions != nil {
+		partitions, err := s.partitions.Partitions()
+		if err != nil {
+			return nil, err
+		}
+		// The relay owns no partition until the instances have rebalanced
+		if len(partitions.IDs) == 0 {
+			return nil, nil
+		}
+		filter.Partitions = &partitions
+	}
+
+	if s.tenantFairness {
+		// Every batch starts with the next tenant, however many claims its lanes make
+		claim = s.fairClaim(dbRepo, claim, sticky, int(s.tenantCursor.Add(1)-1))
+	}
+	if len(s.lanes) == 0 {
+		return claim(filter, s.batchSize)
+	}
+
+	// Higher priority lanes come first, each lane is guaranteed its weighted share of the batch
+	return claimShares(quotas(s.lanes, s.batchSize), s.batchSize, sticky, func(i, limit int) ([]outbox.Message, error) {
+		return claim(laneFilter(filter, s.lanes[i]), limit)
+	})
+}
+
+// claimShares claims up to limit messages from several sources, e.g. lanes or tenants, in order.
+// Each source first claims its quota. Slots left over by sources without enough due messages then
+// go to the first sources that have more.
+func claimShares(quota []int, limit int, sticky bool, claim func(i, limit int) ([]outbox.Message, error)) ([]outbox.Message, error) {
+	claimed := make([][]outbox.Message, len(quota))
+	requested := make([]int, len(quota))
+	left := limit
+	for i := range quota {
+		if left <= 0 {
+			break
+		}
+		requested[i] = min(quota[i], left)
+		messages, err := claim(i, requested[i])
+		if err != nil {
+			return nil, err
+		}
+		claimed[i] = messages
+		left -= len(messages)
+	}
+
+	for i := range quota {
+		if left <= 0 {
+			break
+		}
+		if requested[i] == 0 || len(claimed[i]) < requested[i] {
+			continue
+		}
+		more := left
+		if sticky {
+			// The source's messages are claimed again, so ask for more and keep only the new ones
+			more += len(claimed[i])
+		}
+		messages, err := claim(i, more)
+		if err != nil {
+			return nil, err
+		}
+		messages = unclaimed(messages, claimed[i])
+		messages = messages[:min(len(messages), left)]
+		claimed[i] = append(claimed[i], messages...)
+		left -= len(messages)
+	}
+
+	var messages []outbox.Message
+	for _, source := range claimed {
+		messages = append(messages, source...)
+	}
+	return messages, nil
+}
+
+// unclaimed returns the messages that are not among the claimed ones
+func unclaimed(messages, claimed []outbox.Message) []outbox.Message {
+	ids := make(map[uint]bool, len(claimed))
+	for _, message := range claimed {
+		ids[message.ID] = true
+	}
+	fresh := messages[:0:0]
+	for _, message := range messages {
+		if !ids[message.ID] {
+			fresh = append(fresh, message)
+		}
+	}
+	return fresh
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/outbox-go-sdk/internal/claimcheck"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/publisher/nats"
+)
+
+// ErrPayloadTooLarge is returned when a message exceeds the broker's max payload and no claim-check store is configured
+var ErrPayloadTooLarge = errors.New("message exceeds the NATS max payload")
+
+// claimCheck replaces a message too large for the broker by a reference to its payload in the blob store
+func (s *service) claimCheck(message outbox.Message, data []byte, headers map[string]string) ([]byte, map[string]string, error) {
+	maxPayload := s.msgRepo.MaxPayload()
+	if maxPayload <= 0 || nats.MessageSize(data, headers) <= maxPayload {
+		return data, headers, nil
+	}
+	if s.claimCheckConfig == nil {
+		return nil, nil, fmt.Errorf("message %d is %d bytes: %w", message.ID, nats.MessageSize(data, headers), ErrPayloadTooLarge)
+	}
+
+	// The key is stable per message, so a republished message overwrites its own blob
+	key := fmt.Sprintf("%s%d-%d", s.claimCheckConfig.KeyPrefix, message.ID, message.CreatedAt.UnixNano())
+	if err := s.claimCheckConfig.Store.Put(key, data); err != nil {
+		return nil, nil, fmt.Errorf("storing claim-check payload of message %d: %w", message.ID, err)
+	}
+
+	reference, err := claimcheck.NewReference(key, len(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	referenceHeaders := make(map[string]string, len(headers)+2)
+	for k, v := range headers {
+		referenceHeaders[k] = v
+	}
+	// The reference is JSON whatever the payload is, the payload's own type is kept aside
+	if contentType, ok := headers[nats.ContentTypeHeader]; ok {
+		referenceHeaders[claimcheck.OriginalContentTypeHeader] = contentType
+	}
+	referenceHeaders[nats.ContentTypeHeader] = claimcheck.ContentType
+	referenceHeaders[claimcheck.Header] = key
+	return reference, referenceHeaders, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"time"
+
+	"github.com/outbox-go-sdk/internal/codec"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+)
+
+// MessageOption sets optional fields of a message enqueued with Enqueue
+type MessageOption func(*outbox.Message)
+
+// DeliverAfter delays the publication of the message until the given time
+func DeliverAfter(t time.Time) MessageOption {
+	return func(m *outbox.Message) {
+		m.DeliverAfter = t
+	}
+}
+
+// ExpiresAt discards the message if it has not been published by the given time
+func ExpiresAt(t time.Time) MessageOption {
+	return func(m *outbox.Message) {
+		m.ExpiresAt = t
+	}
+}
+
+// WithEventType sets the event type of the message, e.g. used as the CloudEvents type
+func WithEventType(eventType string) MessageOption {
+	return func(m *outbox.Message) {
+		m.EventType = eventType
+	}
+}
+
+// WithTenant assigns the message to a tenant of a multi-tenant outbox
+func WithTenant(tenantID string) MessageOption {
+	return func(m *outbox.Message) {
+		m.TenantID = tenantID
+	}
+}
+
+// WithPartitionKey keeps the message in order with the other messages of the same key when the relays are sharded
+func WithPartitionKey(key string) MessageOption {
+	return func(m *outbox.Message) {
+		m.PartitionKey = key
+	}
+}
+
+// WithPriority sets the priority of the message, higher priorities are served first by priority lanes
+func WithPriority(priority int) MessageOption {
+	return func(m *outbox.Message) {
+		m.Priority = priority
+	}
+}
+
+// Enqueue serializes a typed event with the codec and adds it to the outbox table.
+// The codec name is recorded in the message headers so consumers can decode it with codec.Decode.
+func Enqueue[T any](s Service, c codec.Codec, event T, opts ...MessageOption) error {
+	payload, err := c.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	message := outbox.Message{
+		Payload:     payload,
+		ContentType: c.ContentType(),
+		Headers:     outbox.Headers{codec.Header: c.Name()},
+	}
+	for _, opt := range opts {
+		opt(&message)
+	}
+	return s.EnqueueMessage(message)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+)
+
+// Lane is a range of message priorities served with a weighted share of every batch
+type Lane struct {
+	Name        string
+	MinPriority int
+	MaxPriority int
+	// Weight is the lane's share of a batch relative to the other lanes. Every lane is
+	// guaranteed at least one message per batch so lower priorities are never starved.
+	Weight int
+}
+
+// ValidateLanes checks that the lanes have valid, non-overlapping priority ranges
+func ValidateLanes(lanes []Lane) error {
+	if len(lanes) == 0 {
+		return errors.New("at least one lane is required")
+	}
+	for i, lane := range lanes {
+		if lane.MinPriority > lane.MaxPriority {
+			return fmt.Errorf("lane %q: min priority %d is above max priority %d", lane.Name, lane.MinPriority, lane.MaxPriority)
+		}
+		if lane.Weight < 1 {
+			return fmt.Errorf("lane %q: weight must be at least 1", lane.Name)
+		}
+		for _, other := range lanes[:i] {
+			if lane.MinPriority <= other.MaxPriority && other.MinPriority <= lane.MaxPriority {
+				return fmt.Errorf("lane %q overlaps lane %q", lane.Name, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// quotas splits the batch size across the lanes by weight, giving every lane at least one slot.
+// The quotas never add up to more than the batch size: the slots given to lanes whose share rounds
+// down to zero are taken from the largest quotas, and with more lanes than slots the lowest lanes get none.
+func quotas(lanes []Lane, batchSize int) []int {
+	total := 0
+	for _, lane := range lanes {
+		total += lane.Weight
+	}
+	result := make([]int, len(lanes))
+	sum := 0
+	for i, lane := range lanes {
+		result[i] = max(1, batchSize*lane.Weight/total)
+		sum += result[i]
+	}
+	for ; sum > batchSize; sum-- {
+		largest := 0
+		for i := range result {
+			if result[i] > result[largest] {
+				largest = i
+			}
+		}
+		if result[largest] <= 1 {
+			// Every lane is down to one slot, the lanes after the batch size go without
+			for i := batchSize; i < len(result); i++ {
+				result[i] = 0
+			}
+			break
+		}
+		result[largest]--
+	}
+	return result
+}
+
+// laneFilter further restricts a claim to the lane's priorities
+func laneFilter(filter outbox.Filter, lane Lane) outbox.Filter {
+	filter.Priority = &outbox.PriorityRange{Min: lane.MinPriority, Max: lane.MaxPriority}
+	return filter
+}
+
+// sortLanes orders lanes highest priority first
+func sortLanes(lanes []Lane) []Lane {
+	sorted := append([]Lane(nil), lanes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MaxPriority > sorted[j].MaxPriority })
+	return sorted
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	db "github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/publisher/nats"
+)
+
+// LeaseConfig configures lease-based claiming
+type LeaseConfig struct {
+	// Owner identifies the relay worker holding the leases, e.g. its host name and process id
+	Owner string
+	// TTL is how long claimed messages stay in flight before they may be returned to pending.
+	// Publishing, retries included, stops after half of it so the batch is marked before the leases expire.
+	TTL time.Duration
+}
+
+// errLeaseDeadline is recorded for the messages released because their lease was about to expire
+var errLeaseDeadline = errors.New("lease about to expire, publishing stopped")
+
+// processLeased claims a batch in its own short transaction, publishes it outside of any transaction,
+// then marks the confirmed messages as processed. Messages that cannot be published are released
+// back to pending with a backoff based on their attempts, and their error is recorded.
+func (s *service) processLeased() error {
+	// Measured from before the claim, so the leases cannot expire before the deadline
+	deadline := s.now().Add(s.lease.TTL / 2)
+	messages, err := s.claimBatch(s.dbRepo, func(filter outbox.Filter, limit int) ([]outbox.Message, error) {
+		return s.dbRepo.ClaimMessages(filter, s.lease.Owner, s.lease.TTL, limit)
+	}, false)
+	if err != nil {
+		log.Printf("Error claiming messages: %v", err)
+		return err
+	}
+
+	batch := make([]nats.Message, 0, len(messages))
+	claimed := make([]outbox.Message, 0, len(messages))
+	expired := 0
+	for _, message := range messages {
+		// Expired messages are worthless to consumers, retire them instead of publishing
+		if message.IsExpired(s.now()) {
+			if err := s.dbRepo.MarkMessageAsExpired(message); err != nil {
+				log.Printf("Error marking message as expired: %v", err)
+				return err
+			}
+			expired++
+			continue
+		}
+
+		data, headers, err := s.encode(message)
+		if err != nil {
+			log.Printf("Error encoding message %d: %v", message.ID, err)
+			if err := s.release(message, err); err != nil {
+				return err
+			}
+			continue
+		}
+		batch = append(batch, nats.Message{Subject: s.subject(message), Data: data, Headers: headers})
+		claimed = append(claimed, message)
+	}
+
+	var errs []error
+	if s.pipelined {
+		errs = s.publishBatch(batch, deadline)
+	} else {
+		errs = s.publishEach(batch, deadline)
+	}
+
+	confirmed := make([]uint, 0, len(claimed))
+	for i, message := range claimed {
+		if errs[i] != nil {
+			log.Printf("Error publishing message %d: %v", message.ID, errs[i])
+			if err := s.release(message, errs[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		confirmed = append(confirmed, message.ID)
+	}
+
+	// Mark all confirmed messages still leased to the relay as processed at once
+	if len(confirmed) > 0 {
+		marked, err := s.dbRepo.MarkLeasedMessagesAsProcessed(s.lease.Owner, confirmed)
+		if err != nil {
+			log.Printf("Error marking messages as processed: %v", err)
+			return err
+		}
+		if lost := len(confirmed) - int(marked); lost > 0 {
+			log.Printf("%d published messages lost their lease and may be published again", lost)
+		}
+	}
+
+	s.metrics.IncPublished(len(confirmed))
+	s.metrics.IncExpired(expired)
+	return nil
+}
+
+// publishEach publishes the messages one at a time, then flushes so that only the messages
+// the server received are confirmed. Messages left when the deadline passes are not published.
+// It returns one error per message, nil for those confirmed.
+func (s *service) publishEach(batch []nats.Message, deadline time.Time) []error {
+	errs := make([]error, len(batch))
+	published := false
+	for i, message := range batch {
+		if !s.now().Before(deadline) {
+			errs[i] = errLeaseDeadline
+			continue
+		}
+		if errs[i] = s.publishBefore(deadline, message.Subject, message.Data, message.Headers); errs[i] == nil {
+			published = true
+		}
+	}
+	if published {
+		if err := s.msgRepo.Flush(); err != nil {
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = err
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// release returns a message that could not be published to pending, due again after a backoff based on its attempts
+func (s *service) release(message outbox.Message, cause error) error {
+	retryAt := s.now().Add(s.retryPolicy.Backoff(message.Attempts))
+	if err := s.dbRepo.ReleaseMessage(message, retryAt, cause.Error()); err != nil {
+		log.Printf("Error releasing message %d: %v", message.ID, err)
+		return err
+	}
+	return nil
+}
+
+// RunReaper returns in-flight messages whose lease expired, e.g. because their worker crashed,
+// to pending every interval until the context is cancelled
+func RunReaper(ctx context.Context, dbRepo db.Repository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		released, err := dbRepo.ReleaseExpiredLeases()
+		if err != nil {
+			log.Printf("Error releasing expired leases: %v", err)
+		} else if released > 0 {
+			log.Printf("Released %d messages with an expired lease", released)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"github.com/outbox-go-sdk/internal/claimcheck"
+	"github.com/outbox-go-sdk/internal/cloudevents"
+	"github.com/outbox-go-sdk/internal/compression"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/encryption"
+	"github.com/outbox-go-sdk/internal/metrics"
+)
+
+// Option configures optional behaviour of the service
+type Option func(*service)
+
+// WithRetryPolicy sets the policy used when publishing a message fails
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(s *service) {
+		if policy.MaxAttempts < 1 {
+			policy.MaxAttempts = 1
+		}
+		s.retryPolicy = policy
+	}
+}
+
+// WithCloudEvents publishes every message as a CloudEvents 1.0 event
+func WithCloudEvents(config cloudevents.Config) Option {
+	return func(s *service) {
+		s.cloudEvents = &config
+	}
+}
+
+// WithEncryption encrypts payloads when they are enqueued and decrypts them before they are published
+func WithEncryption(encryptor *encryption.Encryptor) Option {
+	return func(s *service) {
+		s.encryptor = encryptor
+	}
+}
+
+// WithCompression compresses payloads reaching the size threshold when they are enqueued.
+// In the relay it decides whether compressed payloads are decompressed before publishing.
+func WithCompression(config compression.Config) Option {
+	return func(s *service) {
+		s.compression = &config
+	}
+}
+
+// WithClaimCheck stores payloads exceeding the NATS max payload in a blob store
+// and publishes a reference to them instead
+func WithClaimCheck(config claimcheck.Config) Option {
+	return func(s *service) {
+		s.claimCheckConfig = &config
+	}
+}
+
+// WithMetrics records the outcome of processed messages
+func WithMetrics(recorder metrics.Recorder) Option {
+	return func(s *service) {
+		s.metrics = recorder
+	}
+}
+
+// WithPriorityLanes claims each batch across the priority lanes, serving higher priorities first
+// while every lane keeps a share of the batch. The lanes must pass ValidateLanes.
+func WithPriorityLanes(lanes ...Lane) Option {
+	return func(s *service) {
+		s.lanes = sortLanes(lanes)
+	}
+}
+
+// WithPipelining publishes each batch asynchronously and awaits all confirmations at once, instead of
+// publishing and marking one message at a time. Confirmed messages are marked processed with a single
+// UPDATE; unconfirmed ones are retried according to the retry policy and otherwise stay pending.
+func WithPipelining() Option {
+	return func(s *service) {
+		s.pipelined = true
+	}
+}
+
+// PartitionSource returns the partitions a sharded relay publishes, e.g. a postgres.PartitionCoordinator
+type PartitionSource interface {
+	Partitions() (outbox.PartitionSet, error)
+}
+
+// WithPartitions only publishes messages of the partitions returned by the source before each batch
+func WithPartitions(source PartitionSource) Option {
+	return func(s *service) {
+		s.partitions = source
+	}
+}
+
+// Leadership tells whether the relay may publish, e.g. a postgres.LeaderElector
+type Leadership interface {
+	// IsLeader reports whether the relay held the leadership when last checked
+	IsLeader() bool
+	// VerifyLeader checks that the relay still holds the leadership
+	VerifyLeader() bool
+}
+
+// WithLeadership only publishes while the relay holds the leadership: it is verified before each batch,
+// and a batch stops at the next message once the leadership is lost, rolling back the rest of it.
+// It relies on batches being published serially, in a transaction. With leases the leadership is
+// only verified before each batch, leased messages a lost leader keeps publishing are not duplicated.
+func WithLeadership(leadership Leadership) Option {
+	return func(s *service) {
+		s.leadership = leadership
+	}
+}
+
+// WithLeases claims each batch by leasing it in a short transaction and publishes it outside of any
+// transaction, instead of holding the rows locked while publishing. Run RunReaper alongside
+// to recover the messages of crashed workers.
+func WithLeases(config LeaseConfig) Option {
+	return func(s *service) {
+		s.lease = &config
+	}
+}
+
+// WithTenantFairness shares every batch equally among the tenants with due messages, starting with
+// the next tenant on every batch, so that a noisy tenant cannot starve the others
+func WithTenantFairness() Option {
+	return func(s *service) {
+		s.tenantFairness = true
+	}
+}
+
+// WithTenantSubjectPrefix publishes the messages of a tenant to "<prefix><tenant id>.outbox",
+// e.g. "tenants.acme.outbox" with the prefix "tenants.". Messages without a tenant keep the "outbox" subject.
+func WithTenantSubjectPrefix(prefix string) Option {
+	return func(s *service) {
+		s.tenantSubjectPrefix = prefix
+	}
+}
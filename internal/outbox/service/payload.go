@@ -0,0 +1,99 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/outbox-go-sdk/internal/compression"
+	"github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/encryption"
+	"github.com/outbox-go-sdk/internal/publisher/nats"
+)
+
+// prepare transforms the payload of a message before it is stored in the outbox table.
+// Encryption happens once the message is inserted, see encrypt.
+func (s *service) prepare(message outbox.Message) (outbox.Message, error) {
+	// Tenant ids become subject tokens when tenants have their own subjects
+	if err := outbox.ValidateTenantID(message.TenantID); err != nil {
+		return message, err
+	}
+
+	// Compress first, encrypted data does not compress
+	if s.compression != nil && message.ContentEncoding == "" && s.compression.ShouldCompress(len(message.Payload)) {
+		compressed, err := compression.Compress(s.compression.Algorithm, message.Payload)
+		if err != nil {
+			return message, fmt.Errorf("compressing payload: %w", err)
+		}
+		message.Payload = compressed
+		message.ContentEncoding = string(s.compression.Algorithm)
+	}
+	return message, nil
+}
+
+// placeholder returns the row inserted for a message to encrypt. The ciphertext is bound to the
+// message id, which the database only assigns at insert, so the payload is left out and stored
+// encrypted by encrypt in the same transaction: the plaintext never reaches the table.
+func (s *service) placeholder(message outbox.Message) (outbox.Message, error) {
+	keyID, err := s.encryptor.CurrentKeyID()
+	if err != nil {
+		return message, fmt.Errorf("encrypting payload: %w", err)
+	}
+	message.Payload = nil
+	// The key id marks the row as encrypted, e.g. so that it is not stored as jsonb
+	message.EncryptionKeyID = keyID
+	return message, nil
+}
+
+// encrypt encrypts the payload of the inserted message with the given id and stores it
+func (s *service) encrypt(dbRepo db.Repository, id uint, payload []byte) error {
+	envelope, err := s.encryptor.Encrypt(id, payload)
+	if err != nil {
+		return fmt.Errorf("encrypting payload: %w", err)
+	}
+	return dbRepo.UpdateMessagePayload(outbox.Message{
+		ID:              id,
+		Payload:         envelope.Ciphertext,
+		EncryptedKey:    envelope.WrappedKey,
+		EncryptionKeyID: envelope.KeyID,
+	})
+}
+
+// restore reverses prepare on a stored message before it is published
+func (s *service) restore(message outbox.Message) (outbox.Message, error) {
+	if message.IsEncrypted() {
+		if s.encryptor == nil {
+			return message, fmt.Errorf("message %d is encrypted but no encryptor is configured", message.ID)
+		}
+		plaintext, err := s.encryptor.Decrypt(message.ID, encryption.Envelope{
+			Ciphertext: message.Payload,
+			WrappedKey: message.EncryptedKey,
+			KeyID:      message.EncryptionKeyID,
+		})
+		if err != nil {
+			return message, fmt.Errorf("decrypting message %d: %w", message.ID, err)
+		}
+		message.Payload = plaintext
+		message.EncryptedKey = nil
+		message.EncryptionKeyID = ""
+	}
+
+	if message.IsCompressed() {
+		if s.compression != nil && s.compression.ForwardCompressed {
+			// Let the consumer decompress, telling it how through the Content-Encoding header
+			headers := make(outbox.Headers, len(message.Headers)+1)
+			for key, value := range message.Headers {
+				headers[key] = value
+			}
+			headers[nats.ContentEncodingHeader] = message.ContentEncoding
+			message.Headers = headers
+		} else {
+			decompressed, err := compression.Decompress(compression.Algorithm(message.ContentEncoding), message.Payload)
+			if err != nil {
+				return message, fmt.Errorf("decompressing message %d: %w", message.ID, err)
+			}
+			message.Payload = decompressed
+		}
+		message.ContentEncoding = ""
+	}
+	return message, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	db "github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/publisher/nats"
+)
+
+// processPipelined publishes the whole batch before awaiting any confirmation, then marks the
+// confirmed messages as processed with one UPDATE. Unconfirmed messages stay pending for the next poll.
+func (s *service) processPipelined(dbRepo db.Repository, messages []outbox.Message) (published, expired int, err error) {
+	batch := make([]nats.Message, 0, len(messages))
+	ids := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		// Expired messages are worthless to consumers, retire them instead of publishing
+		if message.IsExpired(s.now()) {
+			if err = dbRepo.MarkMessageAsExpired(message); err != nil {
+				log.Printf("Error marking message as expired: %v", err)
+				return 0, 0, err
+			}
+			expired++
+			continue
+		}
+
+		data, headers, err := s.encode(message)
+		if err != nil {
+			log.Printf("Error encoding message %d: %v", message.ID, err)
+			return 0, 0, err
+		}
+		batch = append(batch, nats.Message{Subject: s.subject(message), Data: data, Headers: headers})
+		ids = append(ids, message.ID)
+	}
+
+	confirmed := make([]uint, 0, len(ids))
+	for i, err := range s.publishBatch(batch, time.Time{}) {
+		if err == nil {
+			confirmed = append(confirmed, ids[i])
+		}
+	}
+	if failed := len(ids) - len(confirmed); failed > 0 {
+		log.Printf("%d of %d messages were not confirmed and stay pending", failed, len(ids))
+	}
+
+	// Mark all confirmed messages as processed at once
+	if len(confirmed) > 0 {
+		if err = dbRepo.MarkMessagesAsProcessed(confirmed); err != nil {
+			log.Printf("Error marking messages as processed: %v", err)
+			return 0, 0, err
+		}
+	}
+	return len(confirmed), expired, nil
+}
+
+// publishBatch publishes the batch, republishing the unconfirmed messages according to the retry policy
+// until a retry would start after the deadline, if any. It returns the last error of every message,
+// nil for those confirmed.
+func (s *service) publishBatch(batch []nats.Message, deadline time.Time) []error {
+	errs := make([]error, len(batch))
+	pending := make([]int, len(batch))
+	for i := range batch {
+		pending[i] = i
+	}
+
+	for attempt := 1; attempt <= s.retryPolicy.MaxAttempts && len(pending) > 0; attempt++ {
+		messages := make([]nats.Message, len(pending))
+		for j, i := range pending {
+			messages[j] = batch[i]
+		}
+
+		var unconfirmed []int
+		for j, err := range s.msgRepo.PublishBatch(messages) {
+			errs[pending[j]] = err
+			if err != nil {
+				unconfirmed = append(unconfirmed, pending[j])
+			}
+		}
+		pending = unconfirmed
+
+		if len(pending) > 0 {
+			s.metrics.IncFailed(len(pending))
+			if attempt < s.retryPolicy.MaxAttempts {
+				backoff := s.retryPolicy.Backoff(attempt)
+				if !deadline.IsZero() && s.now().Add(backoff).After(deadline) {
+					log.Printf("Publish attempt %d/%d left %d messages unconfirmed, no time left to retry", attempt, s.retryPolicy.MaxAttempts, len(pending))
+					break
+				}
+				log.Printf("Publish attempt %d/%d left %d messages unconfirmed, retrying", attempt, s.retryPolicy.MaxAttempts, len(pending))
+				s.sleep(backoff)
+			}
+		}
+	}
+	return errs
+}
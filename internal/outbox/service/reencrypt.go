@@ -0,0 +1,72 @@
+package service
+
+import (
+	"log"
+
+	db "github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/encryption"
+)
+
+// Reencrypt rewraps the data keys of all messages encrypted with a retired key using the current key.
+// It works through the table in batches, each in its own transaction, and returns the number of rewrapped messages.
+// Retired keys must stay available to the key provider until it has completed.
+func Reencrypt(dbRepo db.Repository, encryptor *encryption.Encryptor, batchSize int) (int, error) {
+	currentKeyID, err := encryptor.CurrentKeyID()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for {
+		count, err := reencryptBatch(dbRepo, encryptor, currentKeyID, batchSize)
+		total += count
+		if err != nil {
+			return total, err
+		}
+		if count < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// reencryptBatch rewraps one batch of messages within a transaction
+func reencryptBatch(dbRepo db.Repository, encryptor *encryption.Encryptor, currentKeyID string, batchSize int) (int, error) {
+	tx := dbRepo.BeginTransaction()
+
+	var err error
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.RollBackTransaction(); rollbackErr != nil {
+				log.Printf("Error rolling back re-encryption: %v", rollbackErr)
+			}
+		}
+	}()
+
+	messages, err := tx.FindMessagesToReencrypt(currentKeyID, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, message := range messages {
+		var envelope encryption.Envelope
+		envelope, err = encryptor.Rewrap(encryption.Envelope{
+			Ciphertext: message.Payload,
+			WrappedKey: message.EncryptedKey,
+			KeyID:      message.EncryptionKeyID,
+		})
+		if err != nil {
+			log.Printf("Error rewrapping key of message %d: %v", message.ID, err)
+			return 0, err
+		}
+		message.EncryptedKey = envelope.WrappedKey
+		message.EncryptionKeyID = envelope.KeyID
+		if err = tx.UpdateMessageEncryption(message); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.CommitTransaction(); err != nil {
+		return 0, err
+	}
+	return len(messages), nil
+}
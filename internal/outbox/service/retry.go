@@ -0,0 +1,40 @@
+package service
+
+import (
+	"math"
+	"time"
+)
+
+// RetryPolicy controls how often publishing a message is attempted before giving up
+type RetryPolicy struct {
+	// MaxAttempts is the total number of publish attempts, including the first one
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt. It doubles after every failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts. Zero means no cap.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy returns a policy that publishes every message exactly once
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// Backoff returns the delay to wait after the given (1-based) failed attempt
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		// Stop doubling before overflowing, attempts keep growing across polls with leases
+		if backoff > math.MaxInt64/2 {
+			break
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return backoff
+}
@@ -1,43 +1,94 @@
 package service
 
 import (
+	"errors"
 	"log"
+	"sync/atomic"
+	"time"
 
-	db "github.com/outbox-go-sdk/internal/db/postgres"
+	"github.com/outbox-go-sdk/internal/claimcheck"
+	"github.com/outbox-go-sdk/internal/cloudevents"
+	"github.com/outbox-go-sdk/internal/compression"
+	db "github.com/outbox-go-sdk/internal/db"
 	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/encryption"
+	"github.com/outbox-go-sdk/internal/metrics"
 	"github.com/outbox-go-sdk/internal/publisher/nats"
 )
 
 type Service interface {
 	CreateOutboxMessage(payload string) error
+	EnqueueMessage(message outbox.Message) error
+	EnqueueMessages(messages []outbox.Message) ([]uint, error)
 	ProcessOutboxMessages() error
 }
 
 // Service defines the logic of handling outbox messages
 type service struct {
-	dbRepo    db.Repository
-	msgRepo   nats.Publisher
-	batchSize int
+	dbRepo      db.Repository
+	msgRepo     nats.Publisher
+	batchSize   int
+	retryPolicy RetryPolicy
+	cloudEvents *cloudevents.Config
+	encryptor   *encryption.Encryptor
+	compression *compression.Config
+	metrics     metrics.Recorder
+	lanes       []Lane
+	pipelined   bool
+	partitions  PartitionSource
+	leadership  Leadership
+	lease       *LeaseConfig
+
+	tenantFairness      bool
+	tenantSubjectPrefix string
+	tenantCursor        atomic.Uint64
+
+	claimCheckConfig *claimcheck.Config
+
+	sleep func(time.Duration)
+	now   func() time.Time
 }
 
 // NewService creates a new instance of Service
-func NewService(dbRepo db.Repository, msgRepo nats.Publisher, batchSize int) Service {
-	return &service{
-		dbRepo:    dbRepo,
-		msgRepo:   msgRepo,
-		batchSize: batchSize,
+func NewService(dbRepo db.Repository, msgRepo nats.Publisher, batchSize int, opts ...Option) Service {
+	s := &service{
+		dbRepo:      dbRepo,
+		msgRepo:     msgRepo,
+		batchSize:   batchSize,
+		retryPolicy: DefaultRetryPolicy(),
+		metrics:     metrics.Noop{},
+		sleep:       time.Sleep,
+		now:         time.Now,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // CreateOutboxMessage creates a new message and adds it to the outbox table
 func (s *service) CreateOutboxMessage(payload string) error {
 	// Create the outbox message
-	message := outbox.Message{
-		Payload: payload,
+	return s.EnqueueMessage(outbox.Message{
+		Payload: []byte(payload),
+	})
+}
+
+// EnqueueMessage adds a prepared message, e.g. with a binary payload and its content type, to the outbox table
+func (s *service) EnqueueMessage(message outbox.Message) error {
+	if s.encryptor != nil {
+		// Encrypting needs the id of the message, which the bulk insert returns
+		_, err := s.EnqueueMessages([]outbox.Message{message})
+		return err
+	}
+
+	message, err := s.prepare(message)
+	if err != nil {
+		log.Printf("Error preparing outbox message: %v", err)
+		return err
 	}
 
 	dbRepo := s.dbRepo.BeginTransaction()
-	var err error
 	defer func() {
 		if err != nil {
 			err = dbRepo.RollBackTransaction()
@@ -62,8 +113,79 @@ func (s *service) CreateOutboxMessage(payload string) error {
 	return nil
 }
 
-// ProcessOutboxMessages retrieves unprocessed messages, publishes them, and marks them as processed
+// EnqueueMessages adds many prepared messages to the outbox table in one transaction and returns their ids
+func (s *service) EnqueueMessages(messages []outbox.Message) ([]uint, error) {
+	prepared := make([]outbox.Message, len(messages))
+	for i, message := range messages {
+		var err error
+		if prepared[i], err = s.prepare(message); err != nil {
+			log.Printf("Error preparing outbox message %d of %d: %v", i+1, len(messages), err)
+			return nil, err
+		}
+	}
+
+	dbRepo := s.dbRepo.BeginTransaction()
+	var err error
+	defer func() {
+		if err != nil {
+			err = dbRepo.RollBackTransaction()
+			if err != nil {
+				log.Printf("Error rolling back transaction: %v", err)
+			}
+		}
+	}()
+
+	rows := prepared
+	if s.encryptor != nil {
+		rows = make([]outbox.Message, len(prepared))
+		for i, message := range prepared {
+			if rows[i], err = s.placeholder(message); err != nil {
+				log.Printf("Error preparing outbox message %d of %d: %v", i+1, len(messages), err)
+				return nil, err
+			}
+		}
+	}
+
+	// Add all messages to the database (outbox table) with multi-row inserts
+	ids, err := dbRepo.CreateOutboxMessages(rows)
+	if err != nil {
+		log.Printf("Error creating %d outbox messages: %v", len(prepared), err)
+		return nil, err
+	}
+
+	// Encrypt the payloads now that the messages have their ids
+	if s.encryptor != nil {
+		for i, id := range ids {
+			if err = s.encrypt(dbRepo, id, prepared[i].Payload); err != nil {
+				log.Printf("Error encrypting outbox message %d: %v", id, err)
+				return nil, err
+			}
+		}
+	}
+
+	// Commit the transaction
+	if err = dbRepo.CommitTransaction(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// ErrLeadershipLost is returned when the relay loses the leadership while publishing a batch
+var ErrLeadershipLost = errors.New("leadership lost while publishing")
+
+// ProcessOutboxMessages retrieves unprocessed messages, publishes them, and marks them as processed.
+// Unless leases are enabled, the messages stay locked in one transaction while they are published.
 func (s *service) ProcessOutboxMessages() error {
+	// A follower, or a leader whose lock is gone, leaves the messages to the leader
+	if s.leadership != nil && !s.leadership.VerifyLeader() {
+		return nil
+	}
+	if s.lease != nil {
+		return s.processLeased()
+	}
+
 	// Start a database transaction
 	dbRepo := s.dbRepo.BeginTransaction()
 
@@ -78,32 +200,143 @@ func (s *service) ProcessOutboxMessages() error {
 	}()
 
 	// Retrieve unprocessed outbox messages
-	messages, err := dbRepo.FindUnprocessedMessages(s.batchSize)
+	messages, err := s.findMessages(dbRepo)
 	if err != nil {
 		log.Printf("Error fetching unprocessed messages: %v", err)
 		return err
 	}
 
-	// Process each message within the transaction
+	// Process the messages within the transaction
+	process := s.processSerially
+	if s.pipelined {
+		process = s.processPipelined
+	}
+	published, expired, err := process(dbRepo, messages)
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err = dbRepo.CommitTransaction(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return err
+	}
+
+	s.metrics.IncPublished(published)
+	s.metrics.IncExpired(expired)
+	return nil
+}
+
+// processSerially publishes the messages one at a time, marking each as processed once published.
+// The batch is flushed at the end so rows are only committed once NATS has received their messages.
+func (s *service) processSerially(dbRepo db.Repository, messages []outbox.Message) (published, expired int, err error) {
 	for _, message := range messages {
+		// Another relay may be elected once the leadership is lost, leave it the rest of the batch
+		if s.leadership != nil && !s.leadership.IsLeader() {
+			log.Printf("Stopping the batch after %d messages: %v", published+expired, ErrLeadershipLost)
+			return 0, 0, ErrLeadershipLost
+		}
+
+		// Expired messages are worthless to consumers, retire them instead of publishing
+		if message.IsExpired(s.now()) {
+			if err = dbRepo.MarkMessageAsExpired(message); err != nil {
+				log.Printf("Error marking message as expired: %v", err)
+				return 0, 0, err
+			}
+			expired++
+			continue
+		}
+
+		var data []byte
+		var headers map[string]string
+		if data, headers, err = s.encode(message); err != nil {
+			log.Printf("Error encoding message %d: %v", message.ID, err)
+			return 0, 0, err
+		}
+
 		// Publish to NATS
-		if err = s.msgRepo.PublishMessage("outbox", []byte(message.Payload)); err != nil {
+		if err = s.publish(s.subject(message), data, headers); err != nil {
 			log.Printf("Error publishing message: %v", err)
-			return err
+			return 0, 0, err
 		}
 
 		// Mark the message as processed
 		if err = dbRepo.MarkMessageAsProcessed(message); err != nil {
 			log.Printf("Error marking message as processed: %v", err)
-			return err
+			return 0, 0, err
 		}
+		published++
 	}
 
-	// Commit the transaction
-	if err = dbRepo.CommitTransaction(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		return err
+	// Confirm the server received the batch before the processed rows are committed
+	if published > 0 {
+		if err = s.msgRepo.Flush(); err != nil {
+			log.Printf("Error confirming published messages: %v", err)
+			return 0, 0, err
+		}
 	}
+	return published, expired, nil
+}
 
-	return nil
+// encode returns the data and headers published for the message
+func (s *service) encode(message outbox.Message) ([]byte, map[string]string, error) {
+	message, err := s.restore(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, headers := message.Payload, headersFor(message)
+	if s.cloudEvents != nil {
+		if data, headers, err = cloudevents.Encode(*s.cloudEvents, message); err != nil {
+			return nil, nil, err
+		}
+	}
+	return s.claimCheck(message, data, headers)
+}
+
+// headersFor returns the NATS headers forwarded with the message, if any
+func headersFor(message outbox.Message) map[string]string {
+	if message.ContentType == "" && len(message.Headers) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(message.Headers)+1)
+	for key, value := range message.Headers {
+		headers[key] = value
+	}
+	if message.ContentType != "" {
+		headers[nats.ContentTypeHeader] = message.ContentType
+	}
+	return headers
+}
+
+// publish sends the data to NATS, retrying according to the retry policy.
+// Messages without headers are published plainly so they also reach servers without header support.
+func (s *service) publish(subject string, data []byte, headers map[string]string) error {
+	return s.publishBefore(time.Time{}, subject, data, headers)
+}
+
+// publishBefore publishes like publish, but gives up retrying when the retry would start after the deadline.
+// A zero deadline never gives up.
+func (s *service) publishBefore(deadline time.Time, subject string, data []byte, headers map[string]string) error {
+	var err error
+	for attempt := 1; attempt <= s.retryPolicy.MaxAttempts; attempt++ {
+		if len(headers) == 0 {
+			err = s.msgRepo.PublishMessage(subject, data)
+		} else {
+			err = s.msgRepo.PublishMessageWithHeaders(subject, data, headers)
+		}
+		if err == nil {
+			return nil
+		}
+		s.metrics.IncFailed(1)
+		if attempt < s.retryPolicy.MaxAttempts {
+			backoff := s.retryPolicy.Backoff(attempt)
+			if !deadline.IsZero() && s.now().Add(backoff).After(deadline) {
+				log.Printf("Publish attempt %d/%d failed, no time left to retry: %v", attempt, s.retryPolicy.MaxAttempts, err)
+				return err
+			}
+			log.Printf("Publish attempt %d/%d failed, retrying: %v", attempt, s.retryPolicy.MaxAttempts, err)
+			s.sleep(backoff)
+		}
+	}
+	return err
 }
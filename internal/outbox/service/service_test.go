@@ -1,19 +1,40 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/outbox-go-sdk/internal/claimcheck"
+	"github.com/outbox-go-sdk/internal/cloudevents"
+	"github.com/outbox-go-sdk/internal/codec"
+	"github.com/outbox-go-sdk/internal/compression"
 	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/encryption"
+	"github.com/outbox-go-sdk/internal/metrics"
 	mock2 "github.com/outbox-go-sdk/internal/mock"
+	"github.com/outbox-go-sdk/internal/publisher/nats"
+
+	nats2 "github.com/nats-io/nats.go"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
+// newPublisherMock returns a publisher mock announcing a 1MB max payload and confirming every flush
+func newPublisherMock() *mock2.PublisherMock {
+	mockPublisher := new(mock2.PublisherMock)
+	mockPublisher.On("MaxPayload").Return(int64(1024 * 1024)).Maybe()
+	mockPublisher.On("Flush").Return(nil).Maybe()
+	return mockPublisher
+}
+
 func TestCreateOutboxMessage_Success(t *testing.T) {
 	mockDB := new(mock2.DBRepoMock)
-	mockPublisher := new(mock2.PublisherMock)
+	mockPublisher := newPublisherMock()
 	service := NewService(mockDB, mockPublisher, 10)
 
 	mockDB.On("BeginTransaction").Return(mockDB)
@@ -27,7 +48,7 @@ func TestCreateOutboxMessage_Success(t *testing.T) {
 
 func TestCreateOutboxMessage_Failure_CreateError(t *testing.T) {
 	mockDB := new(mock2.DBRepoMock)
-	mockPublisher := new(mock2.PublisherMock)
+	mockPublisher := newPublisherMock()
 	service := NewService(mockDB, mockPublisher, 10)
 
 	mockDB.On("BeginTransaction").Return(mockDB)
@@ -39,14 +60,46 @@ func TestCreateOutboxMessage_Failure_CreateError(t *testing.T) {
 	mockDB.AssertExpectations(t)
 }
 
+func TestEnqueueMessages_Success(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10)
+	messages := []outbox.Message{{Payload: []byte("first")}, {Payload: []byte("second")}}
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("CreateOutboxMessages", messages).Return([]uint{7, 8}, nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	ids, err := service.EnqueueMessages(messages)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{7, 8}, ids)
+	mockDB.AssertExpectations(t)
+}
+
+func TestEnqueueMessages_Failure_CreateError(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10)
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("CreateOutboxMessages", mock.Anything).Return(nil, errors.New("db error"))
+	mockDB.On("RollBackTransaction").Return(nil)
+
+	ids, err := service.EnqueueMessages([]outbox.Message{{Payload: []byte("first")}})
+	assert.Error(t, err)
+	assert.Nil(t, ids)
+	mockDB.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "CommitTransaction")
+}
+
 func TestProcessOutboxMessages_Success(t *testing.T) {
 	mockDB := new(mock2.DBRepoMock)
-	mockPublisher := new(mock2.PublisherMock)
+	mockPublisher := newPublisherMock()
 	service := NewService(mockDB, mockPublisher, 10)
 
 	mockDB.On("BeginTransaction").Return(mockDB)
 	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
-		{Payload: "Test Payload"},
+		{Payload: []byte("Test Payload")},
 	}, nil)
 	mockPublisher.On("PublishMessage", "outbox", mock.Anything).Return(nil)
 	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
@@ -58,10 +111,30 @@ func TestProcessOutboxMessages_Success(t *testing.T) {
 	mockPublisher.AssertExpectations(t)
 }
 
-func TestProcessOutboxMessages_Failure_FetchError(t *testing.T) {
+func TestProcessOutboxMessages_Failure_FlushError(t *testing.T) {
 	mockDB := new(mock2.DBRepoMock)
 	mockPublisher := new(mock2.PublisherMock)
 	service := NewService(mockDB, mockPublisher, 10)
+	message := outbox.Message{ID: 1, Payload: []byte("Test Payload")}
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{message}, nil)
+	mockPublisher.On("MaxPayload").Return(int64(1024 * 1024))
+	mockPublisher.On("PublishMessage", "outbox", []byte("Test Payload")).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", message).Return(nil)
+	mockPublisher.On("Flush").Return(nats2.ErrTimeout)
+	mockDB.On("RollBackTransaction").Return(nil)
+
+	err := service.ProcessOutboxMessages()
+	assert.ErrorIs(t, err, nats2.ErrTimeout)
+	mockDB.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "CommitTransaction")
+}
+
+func TestProcessOutboxMessages_Failure_FetchError(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10)
 
 	mockDB.On("BeginTransaction").Return(mockDB)
 	mockDB.On("FindUnprocessedMessages", 10).Return(([]outbox.Message)(nil), errors.New("db error"))
@@ -75,12 +148,12 @@ func TestProcessOutboxMessages_Failure_FetchError(t *testing.T) {
 
 func TestProcessOutboxMessages_Failure_PublishError(t *testing.T) {
 	mockDB := new(mock2.DBRepoMock)
-	mockPublisher := new(mock2.PublisherMock)
+	mockPublisher := newPublisherMock()
 	service := NewService(mockDB, mockPublisher, 10)
 
 	mockDB.On("BeginTransaction").Return(mockDB)
 	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
-		{Payload: "Test Payload"},
+		{Payload: []byte("Test Payload")},
 	}, nil)
 	mockPublisher.On("PublishMessage", "outbox", mock.Anything).Return(errors.New("nats error"))
 	mockDB.On("RollBackTransaction").Return(nil)
@@ -90,3 +163,778 @@ func TestProcessOutboxMessages_Failure_PublishError(t *testing.T) {
 	mockDB.AssertExpectations(t)
 	mockPublisher.AssertExpectations(t)
 }
+
+func TestProcessOutboxMessages_RetriesPublish(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	svc := NewService(mockDB, mockPublisher, 10, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+	var slept []time.Duration
+	svc.(*service).sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
+		{Payload: []byte("Test Payload")},
+	}, nil)
+	mockPublisher.On("PublishMessage", "outbox", mock.Anything).Return(errors.New("nats error")).Once()
+	mockPublisher.On("PublishMessage", "outbox", mock.Anything).Return(nil).Once()
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	err := svc.ProcessOutboxMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Millisecond}, slept)
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertExpectations(t)
+}
+
+func TestProcessOutboxMessages_Failure_RetriesExhausted(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	svc := NewService(mockDB, mockPublisher, 10, WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+	svc.(*service).sleep = func(time.Duration) {}
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
+		{Payload: []byte("Test Payload")},
+	}, nil)
+	mockPublisher.On("PublishMessage", "outbox", mock.Anything).Return(errors.New("nats error")).Twice()
+	mockDB.On("RollBackTransaction").Return(nil)
+
+	err := svc.ProcessOutboxMessages()
+	assert.Error(t, err)
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertExpectations(t)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+
+	assert.Equal(t, 100*time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.Backoff(2))
+	assert.Equal(t, 300*time.Millisecond, policy.Backoff(3))
+	assert.Equal(t, 300*time.Millisecond, policy.Backoff(4))
+
+	// Uncapped backoffs stop growing instead of overflowing after many attempts
+	uncapped := RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Second}
+	assert.Positive(t, uncapped.Backoff(100))
+}
+
+func TestEnqueueMessage_BinaryPayload(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10)
+
+	message := outbox.Message{Payload: []byte{0x08, 0x96, 0x01}, ContentType: "application/x-protobuf"}
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("CreateOutboxMessage", message).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	err := service.EnqueueMessage(message)
+	assert.NoError(t, err)
+	mockDB.AssertExpectations(t)
+}
+
+func TestProcessOutboxMessages_ForwardsContentType(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10)
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
+		{Payload: []byte(`{"id":1}`), ContentType: "application/json"},
+	}, nil)
+	mockPublisher.On("PublishMessageWithHeaders", "outbox", []byte(`{"id":1}`), map[string]string{"Content-Type": "application/json"}).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	err := service.ProcessOutboxMessages()
+	assert.NoError(t, err)
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertExpectations(t)
+}
+
+func TestEnqueue_RecordsCodec(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10)
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("CreateOutboxMessage", outbox.Message{
+		Payload:     []byte(`{"id":7}`),
+		ContentType: "application/json",
+		Headers:     outbox.Headers{codec.Header: "json"},
+	}).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	err := Enqueue(service, codec.JSON, struct {
+		ID int `json:"id"`
+	}{ID: 7})
+	assert.NoError(t, err)
+	mockDB.AssertExpectations(t)
+}
+
+func TestProcessOutboxMessages_CloudEvents(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10, WithCloudEvents(cloudevents.Config{Mode: cloudevents.ModeBinary, Source: "/orders"}))
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
+		{ID: 3, Payload: []byte("Test Payload"), EventType: "order.created"},
+	}, nil)
+	mockPublisher.On("PublishMessageWithHeaders", "outbox", []byte("Test Payload"), map[string]string{
+		"ce-specversion": "1.0",
+		"ce-id":          "3",
+		"ce-source":      "/orders",
+		"ce-type":        "order.created",
+	}).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	err := service.ProcessOutboxMessages()
+	assert.NoError(t, err)
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertExpectations(t)
+}
+
+func newTestEncryptor(t *testing.T, currentKeyID string) *encryption.Encryptor {
+	keys, err := encryption.NewStaticKeyProvider(currentKeyID, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	})
+	require.NoError(t, err)
+	return encryption.NewEncryptor(keys)
+}
+
+func TestEncryption_EnqueueAndProcess(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10, WithEncryption(newTestEncryptor(t, "k1")))
+
+	var stored outbox.Message
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("CreateOutboxMessages", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).([]outbox.Message)[0]
+		stored.ID = 5
+	}).Return([]uint{5}, nil)
+	mockDB.On("UpdateMessagePayload", mock.Anything).Run(func(args mock.Arguments) {
+		encrypted := args.Get(0).(outbox.Message)
+		stored.Payload, stored.EncryptedKey, stored.EncryptionKeyID = encrypted.Payload, encrypted.EncryptedKey, encrypted.EncryptionKeyID
+	}).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	require.NoError(t, service.CreateOutboxMessage("ssn=123-45-6789"))
+	assert.Equal(t, "k1", stored.EncryptionKeyID)
+	assert.NotContains(t, string(stored.Payload), "123-45-6789")
+	mockDB.AssertCalled(t, "CreateOutboxMessages", mock.MatchedBy(func(rows []outbox.Message) bool {
+		return rows[0].Payload == nil
+	}))
+
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{stored}, nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("ssn=123-45-6789")).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+
+	assert.NoError(t, service.ProcessOutboxMessages())
+	mockPublisher.AssertExpectations(t)
+}
+
+func TestProcessOutboxMessages_Failure_EncryptedWithoutEncryptor(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10)
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
+		{ID: 1, Payload: []byte("ciphertext"), EncryptionKeyID: "k1"},
+	}, nil)
+	mockDB.On("RollBackTransaction").Return(nil)
+
+	assert.Error(t, service.ProcessOutboxMessages())
+	mockPublisher.AssertNotCalled(t, "PublishMessage", mock.Anything, mock.Anything)
+}
+
+func TestProcessOutboxMessages_Failure_EncryptedPayloadMoved(t *testing.T) {
+	encryptor := newTestEncryptor(t, "k1")
+	envelope, err := encryptor.Encrypt(1, []byte("payload"))
+	require.NoError(t, err)
+
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10, WithEncryption(encryptor))
+
+	// The ciphertext of message 1 copied into message 2 does not decrypt
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
+		{ID: 2, Payload: envelope.Ciphertext, EncryptedKey: envelope.WrappedKey, EncryptionKeyID: "k1"},
+	}, nil)
+	mockDB.On("RollBackTransaction").Return(nil)
+
+	assert.Error(t, service.ProcessOutboxMessages())
+	mockPublisher.AssertNotCalled(t, "PublishMessage", mock.Anything, mock.Anything)
+}
+
+func TestReencrypt(t *testing.T) {
+	oldEncryptor := newTestEncryptor(t, "k1")
+	envelope, err := oldEncryptor.Encrypt(1, []byte("payload"))
+	require.NoError(t, err)
+
+	mockDB := new(mock2.DBRepoMock)
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindMessagesToReencrypt", "k2", 10).Return([]outbox.Message{
+		{ID: 1, Payload: envelope.Ciphertext, EncryptedKey: envelope.WrappedKey, EncryptionKeyID: "k1"},
+	}, nil)
+	mockDB.On("UpdateMessageEncryption", mock.MatchedBy(func(m outbox.Message) bool {
+		return m.ID == 1 && m.EncryptionKeyID == "k2"
+	})).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	count, err := Reencrypt(mockDB, newTestEncryptor(t, "k2"), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCompression_EnqueueAndProcess(t *testing.T) {
+	payload := bytes.Repeat([]byte("large event "), 100)
+	config := compression.Config{Algorithm: compression.Gzip, Threshold: 512}
+
+	for _, forward := range []bool{false, true} {
+		config.ForwardCompressed = forward
+		mockDB := new(mock2.DBRepoMock)
+		mockPublisher := newPublisherMock()
+		service := NewService(mockDB, mockPublisher, 10, WithCompression(config), WithEncryption(newTestEncryptor(t, "k1")))
+
+		var stored outbox.Message
+		mockDB.On("BeginTransaction").Return(mockDB)
+		mockDB.On("CreateOutboxMessages", mock.Anything).Run(func(args mock.Arguments) {
+			stored = args.Get(0).([]outbox.Message)[0]
+			stored.ID = 5
+		}).Return([]uint{5}, nil)
+		mockDB.On("UpdateMessagePayload", mock.Anything).Run(func(args mock.Arguments) {
+			encrypted := args.Get(0).(outbox.Message)
+			stored.Payload, stored.EncryptedKey, stored.EncryptionKeyID = encrypted.Payload, encrypted.EncryptedKey, encrypted.EncryptionKeyID
+		}).Return(nil)
+		mockDB.On("CommitTransaction").Return(nil)
+
+		require.NoError(t, service.EnqueueMessage(outbox.Message{Payload: payload}))
+		assert.Equal(t, "gzip", stored.ContentEncoding)
+
+		mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{stored}, nil)
+		mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+		if forward {
+			mockPublisher.On("PublishMessageWithHeaders", "outbox", mock.MatchedBy(func(data []byte) bool {
+				decompressed, err := compression.Decompress(compression.Gzip, data)
+				return err == nil && bytes.Equal(decompressed, payload)
+			}), map[string]string{"Content-Encoding": "gzip"}).Return(nil)
+		} else {
+			mockPublisher.On("PublishMessage", "outbox", payload).Return(nil)
+		}
+
+		assert.NoError(t, service.ProcessOutboxMessages())
+		mockPublisher.AssertExpectations(t)
+	}
+}
+
+func TestCompression_BelowThreshold(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10, WithCompression(compression.Config{Algorithm: compression.Zstd, Threshold: 512}))
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("CreateOutboxMessage", outbox.Message{Payload: []byte("small")}).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, service.CreateOutboxMessage("small"))
+	mockDB.AssertExpectations(t)
+}
+
+func TestProcessOutboxMessages_ClaimCheck(t *testing.T) {
+	store, err := claimcheck.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := new(mock2.PublisherMock)
+	mockPublisher.On("MaxPayload").Return(int64(64))
+	mockPublisher.On("Flush").Return(nil)
+	service := NewService(mockDB, mockPublisher, 10, WithClaimCheck(claimcheck.Config{Store: store, KeyPrefix: "orders-"}))
+
+	payload := bytes.Repeat([]byte("x"), 100)
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
+		{ID: 5, Payload: payload, ContentType: "text/plain", CreatedAt: time.Unix(1700000000, 0)},
+		{ID: 6, Payload: []byte("small")},
+	}, nil)
+	mockPublisher.On("PublishMessageWithHeaders", "outbox", []byte(`{"claim_check":{"key":"orders-5-1700000000000000000","size":100}}`), map[string]string{
+		"Content-Type":                    "application/vnd.outbox.claim-check+json",
+		"Outbox-Claim-Check-Content-Type": "text/plain",
+		"Outbox-Claim-Check":              "orders-5-1700000000000000000",
+	}).Return(nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("small")).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	require.NoError(t, service.ProcessOutboxMessages())
+	mockPublisher.AssertExpectations(t)
+
+	resolved, err := claimcheck.Resolve(store, nil, map[string]string{claimcheck.Header: "orders-5-1700000000000000000"})
+	require.NoError(t, err)
+	assert.Equal(t, payload, resolved)
+}
+
+func TestProcessOutboxMessages_Failure_PayloadTooLarge(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := new(mock2.PublisherMock)
+	mockPublisher.On("MaxPayload").Return(int64(64))
+	service := NewService(mockDB, mockPublisher, 10)
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{
+		{ID: 5, Payload: bytes.Repeat([]byte("x"), 100)},
+	}, nil)
+	mockDB.On("RollBackTransaction").Return(nil)
+
+	err := service.ProcessOutboxMessages()
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+	mockPublisher.AssertNotCalled(t, "PublishMessage", mock.Anything, mock.Anything)
+}
+
+func TestEnqueue_DeliverAfter(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	service := NewService(mockDB, mockPublisher, 10)
+	due := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("CreateOutboxMessage", mock.MatchedBy(func(m outbox.Message) bool {
+		return m.DeliverAfter.Equal(due) && m.EventType == "reminder.due"
+	})).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	err := Enqueue(service, codec.JSON, map[string]string{"user": "u-1"}, DeliverAfter(due), WithEventType("reminder.due"))
+	assert.NoError(t, err)
+	mockDB.AssertExpectations(t)
+}
+
+func TestProcessOutboxMessages_SkipsExpired(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	counters := metrics.NewCounters()
+	svc := NewService(mockDB, mockPublisher, 10, WithMetrics(counters))
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	svc.(*service).now = func() time.Time { return now }
+
+	expired := outbox.Message{ID: 1, Payload: []byte("stale quote"), ExpiresAt: now.Add(-time.Minute)}
+	fresh := outbox.Message{ID: 2, Payload: []byte("fresh quote"), ExpiresAt: now.Add(time.Minute)}
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{expired, fresh}, nil)
+	mockDB.On("MarkMessageAsExpired", expired).Return(nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("fresh quote")).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", fresh).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertExpectations(t)
+	assert.Equal(t, int64(1), counters.Expired())
+	assert.Equal(t, int64(1), counters.Published())
+}
+
+func TestProcessOutboxMessages_PriorityLanes(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	low := Lane{Name: "low", MinPriority: -100, MaxPriority: 9, Weight: 1}
+	high := Lane{Name: "high", MinPriority: 10, MaxPriority: 100, Weight: 3}
+	svc := NewService(mockDB, mockPublisher, 10, WithPriorityLanes(low, high))
+
+	var urgent []outbox.Message
+	for id := uint(1); id <= 8; id++ {
+		urgent = append(urgent, outbox.Message{ID: id, Payload: []byte("urgent"), Priority: 50})
+	}
+	bulk := outbox.Message{ID: 20, Payload: []byte("bulk")}
+	highFilter := outbox.Filter{Priority: &outbox.PriorityRange{Min: 10, Max: 100}}
+	lowFilter := outbox.Filter{Priority: &outbox.PriorityRange{Min: -100, Max: 9}}
+
+	// The high lane claims its quota of 7 first, the low lane keeps its share of 2 but only has 1 due
+	// message, and the 2 slots left over go back to the high lane, which only has 1 more.
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessagesMatching", highFilter, 7).Return(urgent[:7], nil).Once()
+	mockDB.On("FindUnprocessedMessagesMatching", lowFilter, 2).Return([]outbox.Message{bulk}, nil).Once()
+	mockDB.On("FindUnprocessedMessagesMatching", highFilter, 9).Return(urgent, nil).Once()
+	mockPublisher.On("PublishMessage", "outbox", mock.Anything).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertNumberOfCalls(t, "PublishMessage", 9)
+	mockDB.AssertNumberOfCalls(t, "MarkMessageAsProcessed", 9)
+	mockDB.AssertCalled(t, "MarkMessageAsProcessed", bulk)
+}
+
+func TestProcessOutboxMessages_PriorityLanes_TopUpReordered(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	low := Lane{Name: "low", MinPriority: -100, MaxPriority: 9, Weight: 1}
+	high := Lane{Name: "high", MinPriority: 10, MaxPriority: 100, Weight: 3}
+	svc := NewService(mockDB, mockPublisher, 10, WithPriorityLanes(low, high))
+
+	var urgent []outbox.Message
+	for id := uint(1); id <= 9; id++ {
+		urgent = append(urgent, outbox.Message{ID: id, Payload: []byte("urgent"), Priority: 50})
+	}
+	highFilter := outbox.Filter{Priority: &outbox.PriorityRange{Min: 10, Max: 100}}
+	lowFilter := outbox.Filter{Priority: &outbox.PriorityRange{Min: -100, Max: 9}}
+
+	// Between the two claims of the high lane a message was inserted ahead of the claimed ones,
+	// so the top-up returns them in another order. Only the 2 new messages fill the slots left.
+	reordered := []outbox.Message{urgent[8], urgent[3], urgent[0], urgent[7], urgent[6], urgent[5], urgent[4], urgent[2], urgent[1]}
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessagesMatching", highFilter, 7).Return(urgent[:7], nil).Once()
+	mockDB.On("FindUnprocessedMessagesMatching", lowFilter, 2).Return([]outbox.Message{}, nil).Once()
+	mockDB.On("FindUnprocessedMessagesMatching", highFilter, 10).Return(reordered, nil).Once()
+	mockPublisher.On("PublishMessage", "outbox", mock.Anything).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertNumberOfCalls(t, "PublishMessage", 9)
+	mockDB.AssertNumberOfCalls(t, "MarkMessageAsProcessed", 9)
+	for _, message := range urgent {
+		mockDB.AssertCalled(t, "MarkMessageAsProcessed", message)
+	}
+}
+
+func TestQuotas_CappedAtBatchSize(t *testing.T) {
+	lanes := []Lane{{Weight: 100}, {Weight: 1}, {Weight: 1}, {Weight: 1}}
+	assert.Equal(t, []int{7, 1, 1, 1}, quotas(lanes, 10))
+	assert.Equal(t, []int{1, 1, 0, 0}, quotas(lanes, 2))
+	assert.Equal(t, []int{2, 7}, quotas([]Lane{{Weight: 1}, {Weight: 3}}, 10))
+}
+
+func TestValidateLanes_Failure(t *testing.T) {
+	assert.Error(t, ValidateLanes(nil))
+	assert.Error(t, ValidateLanes([]Lane{{Name: "inverted", MinPriority: 5, MaxPriority: 1, Weight: 1}}))
+	assert.Error(t, ValidateLanes([]Lane{{Name: "weightless", MaxPriority: 1}}))
+	assert.Error(t, ValidateLanes([]Lane{
+		{Name: "a", MinPriority: 0, MaxPriority: 10, Weight: 1},
+		{Name: "b", MinPriority: 10, MaxPriority: 20, Weight: 1},
+	}))
+	assert.NoError(t, ValidateLanes([]Lane{
+		{Name: "a", MinPriority: 0, MaxPriority: 9, Weight: 1},
+		{Name: "b", MinPriority: 10, MaxPriority: 20, Weight: 2},
+	}))
+}
+
+func TestProcessOutboxMessages_Pipelined(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	counters := metrics.NewCounters()
+	svc := NewService(mockDB, mockPublisher, 10, WithPipelining(), WithMetrics(counters),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+	svc.(*service).sleep = func(time.Duration) {}
+
+	first := outbox.Message{ID: 1, Payload: []byte("first")}
+	second := outbox.Message{ID: 2, Payload: []byte("second"), ContentType: "text/plain"}
+	third := outbox.Message{ID: 3, Payload: []byte("third")}
+	batch := []nats.Message{
+		{Subject: "outbox", Data: []byte("first")},
+		{Subject: "outbox", Data: []byte("second"), Headers: map[string]string{nats.ContentTypeHeader: "text/plain"}},
+		{Subject: "outbox", Data: []byte("third")},
+	}
+
+	// The second message is unconfirmed twice, only the others are marked processed
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{first, second, third}, nil)
+	mockPublisher.On("PublishBatch", batch).Return([]error{nil, nats2.ErrTimeout, nil}).Once()
+	mockPublisher.On("PublishBatch", batch[1:2]).Return([]error{nats2.ErrTimeout}).Once()
+	mockDB.On("MarkMessagesAsProcessed", []uint{1, 3}).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "MarkMessageAsProcessed", mock.Anything)
+	assert.Equal(t, int64(2), counters.Published())
+	assert.Equal(t, int64(2), counters.Failed())
+}
+
+// partitionsFunc is a PartitionSource returning fixed partitions
+type partitionsFunc func() (outbox.PartitionSet, error)
+
+func (f partitionsFunc) Partitions() (outbox.PartitionSet, error) {
+	return f()
+}
+
+func TestProcessOutboxMessages_Partitions(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	owned := outbox.PartitionSet{Count: 8, IDs: []int{1, 5}}
+	svc := NewService(mockDB, mockPublisher, 10, WithPartitions(partitionsFunc(func() (outbox.PartitionSet, error) {
+		return owned, nil
+	})))
+	message := outbox.Message{ID: 1, Payload: []byte("order-1 created"), PartitionKey: "order-1"}
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessagesMatching", outbox.Filter{Partitions: &owned}, 10).Return([]outbox.Message{message}, nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("order-1 created")).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", message).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertExpectations(t)
+}
+
+func TestProcessOutboxMessages_NoPartitionsOwned(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	svc := NewService(mockDB, mockPublisher, 10, WithPartitions(partitionsFunc(func() (outbox.PartitionSet, error) {
+		return outbox.PartitionSet{Count: 8}, nil
+	})))
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertNotCalled(t, "FindUnprocessedMessages", mock.Anything)
+	mockDB.AssertNotCalled(t, "FindUnprocessedMessagesMatching", mock.Anything, mock.Anything)
+}
+
+// fakeLeadership is a Leadership whose lock can be lost at any time
+type fakeLeadership struct {
+	leader   bool
+	verified int
+}
+
+func (l *fakeLeadership) IsLeader() bool { return l.leader }
+
+func (l *fakeLeadership) VerifyLeader() bool {
+	l.verified++
+	return l.leader
+}
+
+func TestProcessOutboxMessages_Failure_NotLeader(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	leadership := &fakeLeadership{}
+	svc := NewService(mockDB, mockPublisher, 10, WithLeadership(leadership))
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	assert.Equal(t, 1, leadership.verified)
+	mockDB.AssertNotCalled(t, "BeginTransaction")
+}
+
+func TestProcessOutboxMessages_Failure_LeadershipLostMidBatch(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	leadership := &fakeLeadership{leader: true}
+	svc := NewService(mockDB, mockPublisher, 10, WithLeadership(leadership))
+	first := outbox.Message{ID: 1, Payload: []byte("first")}
+	second := outbox.Message{ID: 2, Payload: []byte("second")}
+
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindUnprocessedMessages", 10).Return([]outbox.Message{first, second}, nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("first")).Return(nil).Run(func(mock.Arguments) {
+		leadership.leader = false
+	})
+	mockDB.On("MarkMessageAsProcessed", first).Return(nil)
+	mockDB.On("RollBackTransaction").Return(nil)
+
+	err := svc.ProcessOutboxMessages()
+	assert.ErrorIs(t, err, ErrLeadershipLost)
+	mockPublisher.AssertNotCalled(t, "PublishMessage", "outbox", []byte("second"))
+	mockDB.AssertNotCalled(t, "CommitTransaction")
+	mockDB.AssertExpectations(t)
+}
+
+func TestProcessOutboxMessages_Leased(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	lease := LeaseConfig{Owner: "relay-1", TTL: time.Minute}
+	svc := NewService(mockDB, mockPublisher, 10, WithLeases(lease),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Second}))
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	svc.(*service).now = func() time.Time { return now }
+
+	sent := outbox.Message{ID: 1, Payload: []byte("sent"), LeaseOwner: "relay-1", Attempts: 1}
+	failing := outbox.Message{ID: 2, Payload: []byte("failing"), LeaseOwner: "relay-1", Attempts: 3}
+	mockDB.On("ClaimMessages", outbox.Filter{}, "relay-1", time.Minute, 10).Return([]outbox.Message{sent, failing}, nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("sent")).Return(nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("failing")).Return(errors.New("nats error"))
+	mockDB.On("ReleaseMessage", failing, now.Add(4*time.Second), "nats error").Return(nil)
+	mockDB.On("MarkLeasedMessagesAsProcessed", "relay-1", []uint{1}).Return(int64(1), nil)
+
+	// The claim commits on its own, publishing happens outside of any transaction
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "BeginTransaction")
+}
+
+func TestProcessOutboxMessages_Leased_Failure_NotLeader(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	leadership := &fakeLeadership{}
+	svc := NewService(mockDB, mockPublisher, 10, WithLeadership(leadership),
+		WithLeases(LeaseConfig{Owner: "relay-1", TTL: time.Minute}))
+
+	// A follower claims nothing even though leases would keep its publishes safe
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	assert.Equal(t, 1, leadership.verified)
+	mockDB.AssertNotCalled(t, "ClaimMessages", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessOutboxMessages_Leased_FlushError(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := new(mock2.PublisherMock)
+	svc := NewService(mockDB, mockPublisher, 10, WithLeases(LeaseConfig{Owner: "relay-1", TTL: time.Minute}))
+	message := outbox.Message{ID: 1, Payload: []byte("unconfirmed"), LeaseOwner: "relay-1", Attempts: 1}
+
+	mockDB.On("ClaimMessages", outbox.Filter{}, "relay-1", time.Minute, 10).Return([]outbox.Message{message}, nil)
+	mockPublisher.On("MaxPayload").Return(int64(1024 * 1024))
+	mockPublisher.On("PublishMessage", "outbox", []byte("unconfirmed")).Return(nil)
+	mockPublisher.On("Flush").Return(nats2.ErrTimeout)
+	mockDB.On("ReleaseMessage", message, mock.Anything, nats2.ErrTimeout.Error()).Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "MarkLeasedMessagesAsProcessed", mock.Anything, mock.Anything)
+}
+
+func TestProcessOutboxMessages_Leased_RetriesWithinLease(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	svc := NewService(mockDB, mockPublisher, 10, WithLeases(LeaseConfig{Owner: "relay-1", TTL: 10 * time.Second}),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 5, InitialBackoff: 3 * time.Second}))
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	svc.(*service).now = func() time.Time { return now }
+	svc.(*service).sleep = func(d time.Duration) { now = now.Add(d) }
+
+	failing := outbox.Message{ID: 1, Payload: []byte("failing"), LeaseOwner: "relay-1", Attempts: 1}
+	sent := outbox.Message{ID: 2, Payload: []byte("sent"), LeaseOwner: "relay-1", Attempts: 1}
+	late := outbox.Message{ID: 3, Payload: []byte("late"), LeaseOwner: "relay-1", Attempts: 1}
+	mockDB.On("ClaimMessages", outbox.Filter{}, "relay-1", 10*time.Second, 10).Return([]outbox.Message{failing, sent, late}, nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("failing")).Return(errors.New("nats error"))
+	mockPublisher.On("PublishMessage", "outbox", []byte("sent")).Return(nil).Run(func(mock.Arguments) {
+		now = now.Add(2 * time.Second)
+	})
+	mockDB.On("ReleaseMessage", failing, mock.Anything, "nats error").Return(nil)
+	mockDB.On("ReleaseMessage", late, mock.Anything, errLeaseDeadline.Error()).Return(nil)
+	// The lease of the published message was lost meanwhile, it is left to its new owner
+	mockDB.On("MarkLeasedMessagesAsProcessed", "relay-1", []uint{2}).Return(int64(0), nil)
+
+	// Retrying after 3s fits in the first half of the lease, retrying after 6s more does not,
+	// and the last message is released once that half is over
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockPublisher.AssertNumberOfCalls(t, "PublishMessage", 3)
+	mockPublisher.AssertNotCalled(t, "PublishMessage", "outbox", []byte("late"))
+	mockDB.AssertExpectations(t)
+}
+
+func TestRunReaper(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	ctx, cancel := context.WithCancel(context.Background())
+	mockDB.On("ReleaseExpiredLeases").Return(int64(2), nil).Run(func(mock.Arguments) { cancel() })
+
+	RunReaper(ctx, mockDB, time.Hour)
+	mockDB.AssertNumberOfCalls(t, "ReleaseExpiredLeases", 1)
+}
+
+func TestProcessOutboxMessages_TenantFairness(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	svc := NewService(mockDB, mockPublisher, 4, WithTenantFairness(), WithTenantSubjectPrefix("tenants."))
+
+	tenant := func(id string) outbox.Filter { return outbox.Filter{TenantID: &id} }
+	noisy := []outbox.Message{
+		{ID: 1, Payload: []byte("noisy-1"), TenantID: "noisy"},
+		{ID: 2, Payload: []byte("noisy-2"), TenantID: "noisy"},
+		{ID: 3, Payload: []byte("noisy-3"), TenantID: "noisy"},
+	}
+	quiet := outbox.Message{ID: 9, Payload: []byte("quiet-1"), TenantID: "quiet"}
+	shared := outbox.Message{ID: 12, Payload: []byte("shared-1")}
+
+	// Every tenant gets a slot before the noisy tenant fills the rest of the batch
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindTenantsWithPendingMessages", outbox.Filter{}).Return([]string{"", "noisy", "quiet"}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenant(""), 1).Return([]outbox.Message{shared}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenant("noisy"), 1).Return(noisy[:1], nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenant("quiet"), 1).Return([]outbox.Message{quiet}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenant(""), 2).Return([]outbox.Message{shared}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenant("noisy"), 2).Return(noisy, nil)
+	mockPublisher.On("PublishMessage", "outbox", []byte("shared-1")).Return(nil)
+	mockPublisher.On("PublishMessage", "tenants.noisy.outbox", mock.Anything).Return(nil)
+	mockPublisher.On("PublishMessage", "tenants.quiet.outbox", []byte("quiet-1")).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockDB.AssertExpectations(t)
+	mockPublisher.AssertNumberOfCalls(t, "PublishMessage", 4)
+	mockPublisher.AssertCalled(t, "PublishMessage", "tenants.noisy.outbox", []byte("noisy-2"))
+	mockPublisher.AssertNotCalled(t, "PublishMessage", "tenants.noisy.outbox", []byte("noisy-3"))
+
+	// The next batch starts with the next tenant
+	calls := len(mockDB.Calls)
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	for _, call := range mockDB.Calls[calls:] {
+		if call.Method == "FindUnprocessedMessagesMatching" {
+			assert.Equal(t, tenant("noisy"), call.Arguments.Get(0))
+			break
+		}
+	}
+}
+
+func TestProcessOutboxMessages_TenantFairnessWithLanes(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	mockPublisher := newPublisherMock()
+	svc := NewService(mockDB, mockPublisher, 4, WithTenantFairness(), WithPriorityLanes(
+		Lane{Name: "high", MinPriority: 10, MaxPriority: 100, Weight: 1},
+		Lane{Name: "low", MinPriority: -100, MaxPriority: 9, Weight: 1},
+	))
+
+	high := outbox.Filter{Priority: &outbox.PriorityRange{Min: 10, Max: 100}}
+	low := outbox.Filter{Priority: &outbox.PriorityRange{Min: -100, Max: 9}}
+	a1 := outbox.Message{ID: 1, Payload: []byte("a-1"), TenantID: "a", Priority: 50}
+	b1 := outbox.Message{ID: 2, Payload: []byte("b-1"), TenantID: "b", Priority: 50}
+	a2 := outbox.Message{ID: 3, Payload: []byte("a-2"), TenantID: "a"}
+	a3 := outbox.Message{ID: 4, Payload: []byte("a-3"), TenantID: "a", Priority: 50}
+
+	// The low lane leaves a slot over, which the high lane tops up with its tenants found before
+	mockDB.On("BeginTransaction").Return(mockDB)
+	mockDB.On("FindTenantsWithPendingMessages", high).Return([]string{"a", "b"}, nil)
+	mockDB.On("FindTenantsWithPendingMessages", low).Return([]string{"a"}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenantFilter(high, "a"), 1).Return([]outbox.Message{a1}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenantFilter(high, "b"), 1).Return([]outbox.Message{b1}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenantFilter(low, "a"), 2).Return([]outbox.Message{a2}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenantFilter(high, "a"), 2).Return([]outbox.Message{a1, a3}, nil)
+	mockDB.On("FindUnprocessedMessagesMatching", tenantFilter(high, "b"), 2).Return([]outbox.Message{b1}, nil)
+	mockPublisher.On("PublishMessage", "outbox", mock.Anything).Return(nil)
+	mockDB.On("MarkMessageAsProcessed", mock.Anything).Return(nil)
+	mockDB.On("CommitTransaction").Return(nil)
+
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	mockPublisher.AssertNumberOfCalls(t, "PublishMessage", 4)
+	mockDB.AssertNumberOfCalls(t, "FindTenantsWithPendingMessages", 2)
+
+	// The top-up did not move the cursor: the next batch starts with the second tenant
+	calls := len(mockDB.Calls)
+	assert.NoError(t, svc.ProcessOutboxMessages())
+	for _, call := range mockDB.Calls[calls:] {
+		if call.Method == "FindUnprocessedMessagesMatching" {
+			assert.Equal(t, tenantFilter(high, "b"), call.Arguments.Get(0))
+			break
+		}
+	}
+	mockDB.AssertNumberOfCalls(t, "FindTenantsWithPendingMessages", 4)
+}
+
+func TestEnqueueMessage_Failure_InvalidTenant(t *testing.T) {
+	mockDB := new(mock2.DBRepoMock)
+	service := NewService(mockDB, newPublisherMock(), 10)
+
+	err := service.EnqueueMessage(outbox.Message{Payload: []byte("hi"), TenantID: "acme.*"})
+	assert.Error(t, err)
+	mockDB.AssertNotCalled(t, "BeginTransaction")
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"slices"
+
+	db "github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+)
+
+// fairClaim wraps the claims of a batch so that each is shared equally by the tenants with due messages,
+// in round-robin order starting with the given turn, so a noisy tenant cannot starve the others.
+// The tenants are looked up once per priority range, the top-up claims of a lane reuse them.
+func (s *service) fairClaim(dbRepo db.Repository, claim claimFunc, sticky bool, turn int) claimFunc {
+	found := map[outbox.PriorityRange][]string{}
+	return func(filter outbox.Filter, limit int) ([]outbox.Message, error) {
+		var priority outbox.PriorityRange
+		if filter.Priority != nil {
+			priority = *filter.Priority
+		}
+		tenants, ok := found[priority]
+		if !ok {
+			var err error
+			if tenants, err = dbRepo.FindTenantsWithPendingMessages(filter); err != nil {
+				return nil, err
+			}
+			found[priority] = tenants
+		}
+		if len(tenants) == 0 {
+			return nil, nil
+		}
+
+		start := turn % len(tenants)
+		tenants = slices.Concat(tenants[start:], tenants[:start])
+
+		quota := make([]int, len(tenants))
+		for i := range quota {
+			quota[i] = max(1, limit/len(tenants))
+		}
+		return claimShares(quota, limit, sticky, func(i, limit int) ([]outbox.Message, error) {
+			return claim(tenantFilter(filter, tenants[i]), limit)
+		})
+	}
+}
+
+// tenantFilter further restricts a claim to the tenant's messages
+func tenantFilter(filter outbox.Filter, tenantID string) outbox.Filter {
+	filter.TenantID = &tenantID
+	return filter
+}
+
+// subject returns the NATS subject a message is published to, prefixed per tenant when configured
+func (s *service) subject(message outbox.Message) string {
+	if s.tenantSubjectPrefix == "" || message.TenantID == "" {
+		return "outbox"
+	}
+	return s.tenantSubjectPrefix + message.TenantID + ".outbox"
+}
@@ -2,6 +2,7 @@ package nats
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/nats-io/nats.go"
 )
@@ -12,12 +13,23 @@ type Config struct {
 	NATSConnection *nats.Conn
 	// URL for NATS connection if a new connection needs to be created
 	URL string
+	// JetStream publishes batches with JetStream PublishAsync and awaits the stream acks,
+	// the subjects must be bound to a stream
+	JetStream bool
+	// AckTimeout bounds how long a batch waits for its acks or flush, defaults to DefaultAckTimeout
+	AckTimeout time.Duration
 }
 
+// DefaultAckTimeout is the time a batch waits for its acks or flush when no AckTimeout is set
+const DefaultAckTimeout = 5 * time.Second
+
 // Validate validates the provided NATS configuration
 func (c *Config) Validate() error {
 	if c.NATSConnection == nil && c.URL == "" {
 		return fmt.Errorf("either NATSConnection or URL must be provided")
 	}
+	if c.AckTimeout < 0 {
+		return fmt.Errorf("ack timeout must not be negative")
+	}
 	return nil
 }
@@ -1,18 +1,57 @@
 package nats
 
 import (
+	"context"
+	"time"
+
 	"github.com/nats-io/nats.go"
 )
 
+// ContentTypeHeader is the NATS header carrying the payload's content type
+const ContentTypeHeader = "Content-Type"
+
+// ContentEncodingHeader is the NATS header carrying the compression of a forwarded compressed payload
+const ContentEncodingHeader = "Content-Encoding"
+
 // Publisher defines methods for interacting with NATS
 type Publisher interface {
 	PublishMessage(subject string, data []byte) error
+	PublishMessageWithHeaders(subject string, data []byte, headers map[string]string) error
+	// PublishBatch publishes all messages without waiting on each one, then awaits their
+	// confirmation. It returns one error per message, nil for those confirmed.
+	PublishBatch(messages []Message) []error
+	// Flush confirms that the server received everything published so far
+	Flush() error
+	// MaxPayload returns the largest message, headers included, the server accepts
+	MaxPayload() int64
 	Close()
 }
 
+// Message is a message published as part of a batch
+type Message struct {
+	Subject string
+	Data    []byte
+	Headers map[string]string
+}
+
+// MessageSize returns the size of a message as counted against max_payload, headers included
+func MessageSize(data []byte, headers map[string]string) int64 {
+	size := int64(len(data))
+	if len(headers) > 0 {
+		// "NATS/1.0\r\n" + one "key: value\r\n" line per header + "\r\n"
+		size += 12
+		for key, value := range headers {
+			size += int64(len(key) + len(value) + 4)
+		}
+	}
+	return size
+}
+
 // publisher implements the Publisher interface using NATS
 type publisher struct {
-	nc *nats.Conn
+	nc         *nats.Conn
+	js         nats.JetStreamContext
+	ackTimeout time.Duration
 }
 
 // NewNatsPublisher creates a new instance of NatsPublisher using the provided config
@@ -36,17 +75,117 @@ func NewNatsPublisher(config *Config) (Publisher, error) {
 		}
 	}
 
-	return &publisher{nc: nc}, nil
+	p := &publisher{nc: nc, ackTimeout: config.AckTimeout}
+	if p.ackTimeout == 0 {
+		p.ackTimeout = DefaultAckTimeout
+	}
+	if config.JetStream {
+		if p.js, err = nc.JetStream(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
 }
 
-// PublishMessage sends a message to a NATS subject
+// PublishMessage sends a message to a NATS subject.
+// It fails instead of buffering the message while the client is disconnected.
 func (r *publisher) PublishMessage(subject string, data []byte) error {
+	if err := r.connected(); err != nil {
+		return err
+	}
 	if err := r.nc.Publish(subject, data); err != nil {
 		return err
 	}
 	return nil
 }
 
+// PublishMessageWithHeaders sends a message with NATS headers to a subject.
+// Headers require a NATS server supporting them (v2.2+).
+func (r *publisher) PublishMessageWithHeaders(subject string, data []byte, headers map[string]string) error {
+	if err := r.connected(); err != nil {
+		return err
+	}
+	if err := r.nc.PublishMsg(newMsg(Message{Subject: subject, Data: data, Headers: headers})); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PublishBatch publishes the messages asynchronously. With JetStream it awaits every stream ack,
+// with core NATS a single flush confirms that the server received the whole batch.
+func (r *publisher) PublishBatch(messages []Message) []error {
+	errs := make([]error, len(messages))
+	if r.js != nil {
+		futures := make([]nats.PubAckFuture, len(messages))
+		for i, message := range messages {
+			futures[i], errs[i] = r.js.PublishMsgAsync(newMsg(message))
+		}
+		// The acks share one deadline: every future still pending when it passes times out
+		ctx, cancel := context.WithTimeout(context.Background(), r.ackTimeout)
+		defer cancel()
+		for i, future := range futures {
+			if future == nil {
+				continue
+			}
+			select {
+			case <-future.Ok():
+			case errs[i] = <-future.Err():
+			case <-ctx.Done():
+				errs[i] = nats.ErrTimeout
+			}
+		}
+		return errs
+	}
+
+	for i, message := range messages {
+		if errs[i] = r.connected(); errs[i] == nil {
+			errs[i] = r.nc.PublishMsg(newMsg(message))
+		}
+	}
+	if err := r.Flush(); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// Flush waits until the server has processed everything published so far, or the ack timeout passes.
+// Core NATS publishes only reach the client's buffer, so a successful flush is what confirms them.
+func (r *publisher) Flush() error {
+	return r.nc.FlushTimeout(r.ackTimeout)
+}
+
+// connected returns an error when the client is not connected, in which case core NATS would
+// silently buffer published messages and lose them if the reconnect fails
+func (r *publisher) connected() error {
+	switch {
+	case r.nc.IsConnected():
+		return nil
+	case r.nc.IsClosed():
+		return nats.ErrConnectionClosed
+	default:
+		return nats.ErrDisconnected
+	}
+}
+
+// newMsg builds the NATS message, only adding headers when there are any
+func newMsg(message Message) *nats.Msg {
+	msg := nats.NewMsg(message.Subject)
+	msg.Data = message.Data
+	for key, value := range message.Headers {
+		msg.Header.Set(key, value)
+	}
+	return msg
+}
+
+// MaxPayload returns the max_payload announced by the connected server
+func (r *publisher) MaxPayload() int64 {
+	return r.nc.MaxPayload()
+}
+
 func (r *publisher) Close() {
 	r.nc.Close()
 }
@@ -1,12 +1,20 @@
-package nats
+package nats_test
 
 import (
 	"errors"
 	"testing"
+	"time"
 
-	"github.com/stretchr/testify/assert"
-
+	"github.com/outbox-go-sdk/internal/domain/outbox"
 	mockNats "github.com/outbox-go-sdk/internal/mock" // Ensure correct import path
+	"github.com/outbox-go-sdk/internal/outbox/service"
+	publisher "github.com/outbox-go-sdk/internal/publisher/nats"
+	"github.com/outbox-go-sdk/outboxtest"
+	"github.com/outbox-go-sdk/outboxtest/natstest"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPublishMessage_Success(t *testing.T) {
@@ -46,3 +54,206 @@ func TestPublisher_Close(t *testing.T) {
 
 	mockPublisher.AssertExpectations(t)
 }
+
+// receiveTimeout bounds how long a test subscriber waits for a published message
+const receiveTimeout = 2 * time.Second
+
+func TestNatsPublisher_PublishMessageWithHeaders(t *testing.T) {
+	srv := natstest.RunServer(t)
+	sub, err := srv.Connect(t).SubscribeSync("orders")
+	require.NoError(t, err)
+
+	pub, err := publisher.NewNatsPublisher(&publisher.Config{URL: srv.ClientURL()})
+	require.NoError(t, err)
+	defer pub.Close()
+
+	require.NoError(t, pub.PublishMessageWithHeaders("orders", []byte(`{"id":1}`), map[string]string{
+		publisher.ContentTypeHeader: "application/json",
+		"Trace-Id":                  "abc",
+	}))
+	require.NoError(t, pub.PublishMessage("orders", []byte("plain")))
+	require.NoError(t, pub.Flush())
+
+	msg, err := sub.NextMsg(receiveTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{"id":1}`), msg.Data)
+	assert.Equal(t, "application/json", msg.Header.Get(publisher.ContentTypeHeader))
+	assert.Equal(t, "abc", msg.Header.Get("Trace-Id"))
+
+	msg, err = sub.NextMsg(receiveTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plain"), msg.Data)
+	assert.Empty(t, msg.Header)
+}
+
+func TestNatsPublisher_PublishBatch(t *testing.T) {
+	srv := natstest.RunServer(t)
+	sub, err := srv.Connect(t).SubscribeSync("orders")
+	require.NoError(t, err)
+
+	pub, err := publisher.NewNatsPublisher(&publisher.Config{URL: srv.ClientURL()})
+	require.NoError(t, err)
+	defer pub.Close()
+
+	errs := pub.PublishBatch([]publisher.Message{
+		{Subject: "orders", Data: []byte("1")},
+		{Subject: "orders", Data: []byte("2"), Headers: map[string]string{"Trace-Id": "abc"}},
+		{Subject: "orders", Data: []byte("3")},
+	})
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+
+	for _, expected := range []string{"1", "2", "3"} {
+		msg, err := sub.NextMsg(receiveTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(msg.Data))
+	}
+}
+
+func TestNatsPublisher_PublishBatch_JetStreamAcks(t *testing.T) {
+	srv := natstest.RunServer(t, natstest.WithJetStream())
+	srv.AddStream(t, "OUTBOX", "outbox.>")
+
+	pub, err := publisher.NewNatsPublisher(&publisher.Config{URL: srv.ClientURL(), JetStream: true, AckTimeout: time.Second})
+	require.NoError(t, err)
+	defer pub.Close()
+
+	// Only messages stored by a stream are acked, the one no stream listens to fails
+	errs := pub.PublishBatch([]publisher.Message{
+		{Subject: "outbox.orders", Data: []byte("1")},
+		{Subject: "unbound", Data: []byte("2")},
+		{Subject: "outbox.orders", Data: []byte("3"), Headers: map[string]string{"Trace-Id": "abc"}},
+	})
+	require.Len(t, errs, 3)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.NoError(t, errs[2])
+
+	js, err := srv.Connect(t).JetStream()
+	require.NoError(t, err)
+	info, err := js.StreamInfo("OUTBOX")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+	stored, err := js.GetMsg("OUTBOX", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", stored.Header.Get("Trace-Id"))
+}
+
+func TestNatsPublisher_PublishBatch_Failure_AckTimeout(t *testing.T) {
+	srv := natstest.RunServer(t, natstest.WithJetStream())
+	// A plain subscriber receives the messages but never acks them
+	_, err := srv.Connect(t).Subscribe("silent.>", func(*nats.Msg) {})
+	require.NoError(t, err)
+
+	ackTimeout := 200 * time.Millisecond
+	pub, err := publisher.NewNatsPublisher(&publisher.Config{URL: srv.ClientURL(), JetStream: true, AckTimeout: ackTimeout})
+	require.NoError(t, err)
+	defer pub.Close()
+
+	start := time.Now()
+	errs := pub.PublishBatch([]publisher.Message{
+		{Subject: "silent.1", Data: []byte("1")},
+		{Subject: "silent.2", Data: []byte("2")},
+		{Subject: "silent.3", Data: []byte("3")},
+	})
+	elapsed := time.Since(start)
+
+	require.Len(t, errs, 3)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, nats.ErrTimeout)
+	}
+	assert.GreaterOrEqual(t, elapsed, ackTimeout)
+	assert.Less(t, elapsed, 2*ackTimeout)
+}
+
+func TestNatsPublisher_Failure_Disconnected(t *testing.T) {
+	srv := natstest.RunServer(t)
+	reconnected := make(chan struct{}, 1)
+	nc := srv.Connect(t,
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.ReconnectHandler(func(*nats.Conn) { reconnected <- struct{}{} }),
+	)
+	pub, err := publisher.NewNatsPublisher(&publisher.Config{NATSConnection: nc, AckTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	// While disconnected publishes fail instead of being buffered
+	srv.Shutdown()
+	require.Eventually(t, func() bool { return !nc.IsConnected() }, receiveTimeout, 10*time.Millisecond)
+	assert.ErrorIs(t, pub.PublishMessage("orders", []byte("lost")), nats.ErrDisconnected)
+	assert.ErrorIs(t, pub.PublishMessageWithHeaders("orders", []byte("lost"), map[string]string{"Trace-Id": "abc"}), nats.ErrDisconnected)
+	assert.ErrorIs(t, pub.PublishBatch([]publisher.Message{{Subject: "orders", Data: []byte("lost")}})[0], nats.ErrDisconnected)
+
+	srv.Restart(t)
+	select {
+	case <-reconnected:
+	case <-time.After(receiveTimeout):
+		t.Fatal("client did not reconnect")
+	}
+	sub, err := srv.Connect(t).SubscribeSync("orders")
+	require.NoError(t, err)
+	require.NoError(t, pub.PublishMessage("orders", []byte("delivered")))
+	require.NoError(t, pub.Flush())
+	msg, err := sub.NextMsg(receiveTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("delivered"), msg.Data)
+
+	pub.Close()
+	assert.ErrorIs(t, pub.PublishMessage("orders", []byte("closed")), nats.ErrConnectionClosed)
+}
+
+func TestNatsPublisher_MaxPayload(t *testing.T) {
+	srv := natstest.RunServer(t, natstest.WithMaxPayload(1024))
+
+	pub, err := publisher.NewNatsPublisher(&publisher.Config{URL: srv.ClientURL()})
+	require.NoError(t, err)
+	defer pub.Close()
+
+	assert.Equal(t, int64(1024), pub.MaxPayload())
+	assert.ErrorIs(t, pub.PublishMessage("orders", make([]byte, 2048)), nats.ErrMaxPayload)
+}
+
+func TestMessageSize(t *testing.T) {
+	srv := natstest.RunServer(t, natstest.WithMaxPayload(1024))
+
+	pub, err := publisher.NewNatsPublisher(&publisher.Config{URL: srv.ClientURL()})
+	require.NoError(t, err)
+	defer pub.Close()
+
+	// A message of exactly max_payload is accepted, one byte more is rejected
+	headers := map[string]string{"Trace-Id": "abc", "Content-Type": "application/json"}
+	data := make([]byte, 1024-publisher.MessageSize(nil, headers))
+	assert.Equal(t, int64(1024), publisher.MessageSize(data, headers))
+	assert.NoError(t, pub.PublishMessageWithHeaders("orders", data, headers))
+	assert.ErrorIs(t, pub.PublishMessageWithHeaders("orders", append(data, 0), headers), nats.ErrMaxPayload)
+	assert.Equal(t, int64(3), publisher.MessageSize([]byte("abc"), nil))
+}
+
+func TestNatsPublisher_RelayEndToEnd(t *testing.T) {
+	srv := natstest.RunServer(t, natstest.WithJetStream())
+	srv.AddStream(t, "OUTBOX", "outbox")
+
+	pub, err := publisher.NewNatsPublisher(&publisher.Config{URL: srv.ClientURL(), JetStream: true})
+	require.NoError(t, err)
+	defer pub.Close()
+	repo := outboxtest.NewRepository()
+	svc := service.NewService(repo, pub, 10, service.WithPipelining())
+
+	_, err = svc.EnqueueMessages([]outbox.Message{
+		{Payload: []byte(`{"id":1}`), ContentType: "application/json", Headers: outbox.Headers{"Trace-Id": "abc"}},
+		{Payload: []byte(`{"id":2}`), ContentType: "application/json"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, svc.ProcessOutboxMessages())
+
+	// Messages are only marked processed once their stream acked them
+	for _, message := range repo.Messages() {
+		assert.Equal(t, outbox.StatusProcessed, message.Status)
+	}
+	js, err := srv.Connect(t).JetStream()
+	require.NoError(t, err)
+	first, err := js.GetMsg("OUTBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{"id":1}`), first.Data)
+	assert.Equal(t, "application/json", first.Header.Get(publisher.ContentTypeHeader))
+	assert.Equal(t, "abc", first.Header.Get("Trace-Id"))
+}
@@ -0,0 +1,627 @@
+package outboxtest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RepositoryFactory returns a repository over a fresh, empty outbox. It is called once per subtest
+// and should remove what it created with t.Cleanup.
+type RepositoryFactory func(t *testing.T) db.Repository
+
+// ContractOption adapts the contract to the backend
+type ContractOption func(*contract)
+
+type contract struct {
+	rowLocks bool
+}
+
+// WithoutRowLocks declares a backend that serializes transactions instead of locking rows, like SQLite.
+// Its concurrent relays wait for each other rather than skip the rows locked by another.
+func WithoutRowLocks() ContractOption {
+	return func(c *contract) { c.rowLocks = false }
+}
+
+// RunRepositoryContract verifies that the repositories created by the factory behave like every other
+// storage backend of the outbox: enqueueing, publishing order, concurrent claiming, retry bookkeeping,
+// rollbacks and the deletion of finished messages. Backends run it against a real database in their tests.
+func RunRepositoryContract(t *testing.T, newRepository RepositoryFactory, options ...ContractOption) {
+	c := &contract{rowLocks: true}
+	for _, option := range options {
+		option(c)
+	}
+
+	t.Run("CreateAndFind", func(t *testing.T) { testCreateAndFind(t, newRepository(t)) })
+	t.Run("BulkCreate", func(t *testing.T) { testBulkCreate(t, newRepository(t)) })
+	t.Run("Ordering", func(t *testing.T) { testOrdering(t, newRepository(t)) })
+	t.Run("DeliverAfter", func(t *testing.T) { testDeliverAfter(t, newRepository(t)) })
+	t.Run("PriorityOrder", func(t *testing.T) { testPriorityOrder(t, newRepository(t)) })
+	t.Run("Partitions", func(t *testing.T) { testPartitions(t, newRepository(t)) })
+	t.Run("Tenants", func(t *testing.T) { testTenants(t, newRepository(t)) })
+	if c.rowLocks {
+		t.Run("SkipLocked", func(t *testing.T) { testSkipLocked(t, newRepository(t)) })
+		t.Run("PartitionTakeover", func(t *testing.T) { testPartitionTakeover(t, newRepository(t)) })
+	}
+	t.Run("ConcurrentClaims", func(t *testing.T) { testConcurrentClaims(t, newRepository(t)) })
+	t.Run("ConcurrentRelays", func(t *testing.T) { testConcurrentRelays(t, newRepository(t)) })
+	t.Run("Rollback", func(t *testing.T) { testRollback(t, newRepository(t)) })
+	t.Run("MarkProcessed", func(t *testing.T) { testMarkProcessed(t, newRepository(t)) })
+	t.Run("ClaimAndRelease", func(t *testing.T) { testClaimAndRelease(t, newRepository(t)) })
+	t.Run("RetryBookkeeping", func(t *testing.T) { testRetryBookkeeping(t, newRepository(t)) })
+	t.Run("ExpiredLeases", func(t *testing.T) { testExpiredLeases(t, newRepository(t)) })
+	t.Run("MarkLeased", func(t *testing.T) { testMarkLeased(t, newRepository(t)) })
+	t.Run("Cleanup", func(t *testing.T) { testCleanup(t, newRepository(t)) })
+	t.Run("ListAndStats", func(t *testing.T) { testListAndStats(t, newRepository(t)) })
+	t.Run("EncryptedPayload", func(t *testing.T) { testEncryptedPayload(t, newRepository(t)) })
+	t.Run("Reencrypt", func(t *testing.T) { testReencrypt(t, newRepository(t)) })
+}
+
+func testCreateAndFind(t *testing.T, repo db.Repository) {
+	message := outbox.Message{
+		Payload:     []byte(`{"order":1}`),
+		ContentType: "application/json",
+		EventType:   "order.created",
+		Headers:     outbox.Headers{"Trace-Id": "abc"},
+	}
+	require.NoError(t, repo.CreateOutboxMessage(message))
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.NotZero(t, messages[0].ID)
+	assert.Equal(t, message.Payload, messages[0].Payload)
+	assert.Equal(t, message.ContentType, messages[0].ContentType)
+	assert.Equal(t, message.EventType, messages[0].EventType)
+	assert.Equal(t, message.Headers, messages[0].Headers)
+	assert.Equal(t, outbox.StatusPending, messages[0].Status)
+}
+
+func testBulkCreate(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{
+		{Payload: []byte("first")}, {Payload: []byte("second")}, {Payload: []byte("third")},
+	})
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+	assert.Less(t, ids[0], ids[1])
+	assert.Less(t, ids[1], ids[2])
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	assert.Equal(t, ids, messageIDs(messages))
+	assert.Equal(t, []byte("second"), messages[1].Payload)
+}
+
+func testOrdering(t *testing.T, repo db.Repository) {
+	earlier := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{
+		{Payload: []byte("second"), DeliverAfter: earlier},
+		{Payload: []byte("first"), DeliverAfter: earlier.Add(-time.Minute)},
+		{Payload: []byte("fourth")},
+		{Payload: []byte("third"), DeliverAfter: earlier},
+	})
+	require.NoError(t, err)
+
+	// Messages are published by due time, then in enqueue order
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{ids[1], ids[0], ids[3], ids[2]}, messageIDs(messages))
+
+	batch, err := repo.FindUnprocessedMessages(2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{ids[1], ids[0]}, messageIDs(batch))
+
+	claimed, err := repo.ClaimMessages(outbox.Filter{}, "worker", time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{ids[1], ids[0], ids[3], ids[2]}, messageIDs(claimed))
+}
+
+func testDeliverAfter(t *testing.T, repo db.Repository) {
+	require.NoError(t, repo.CreateOutboxMessage(outbox.Message{Payload: []byte("later"), DeliverAfter: time.Now().Add(time.Hour)}))
+	require.NoError(t, repo.CreateOutboxMessage(outbox.Message{Payload: []byte("now")}))
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, []byte("now"), messages[0].Payload)
+}
+
+func testPriorityOrder(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{
+		{Payload: []byte("low"), Priority: 1},
+		{Payload: []byte("high"), Priority: 9},
+		{Payload: []byte("other lane"), Priority: 20},
+	})
+	require.NoError(t, err)
+
+	messages, err := repo.FindUnprocessedMessagesMatching(outbox.Filter{Priority: &outbox.PriorityRange{Min: 0, Max: 10}}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{ids[1], ids[0]}, messageIDs(messages))
+}
+
+func testPartitions(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{
+		{PartitionKey: "order-1"}, {PartitionKey: "order-1"}, {PartitionKey: "order-2"}, {PartitionKey: "order-3"}, {},
+	})
+	require.NoError(t, err)
+
+	// Every message lands in exactly one partition, messages sharing a key in the same one
+	seen := map[uint]int{}
+	for partition := 0; partition < 3; partition++ {
+		filter := outbox.Filter{Partitions: &outbox.PartitionSet{Count: 3, IDs: []int{partition}}}
+		messages, err := repo.FindUnprocessedMessagesMatching(filter, 10)
+		require.NoError(t, err)
+		for _, message := range messages {
+			seen[message.ID] = partition
+		}
+	}
+	assert.Len(t, seen, len(ids))
+	assert.Equal(t, seen[ids[0]], seen[ids[1]])
+
+	none, err := repo.FindUnprocessedMessagesMatching(outbox.Filter{Partitions: &outbox.PartitionSet{Count: 3}}, 10)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func testTenants(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{{TenantID: "acme"}, {TenantID: "globex"}, {}})
+	require.NoError(t, err)
+
+	tenants, err := repo.FindTenantsWithPendingMessages(outbox.Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "acme", "globex"}, tenants)
+
+	acme := "acme"
+	messages, err := repo.FindUnprocessedMessagesMatching(outbox.Filter{TenantID: &acme}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{ids[0]}, messageIDs(messages))
+
+	none := ""
+	messages, err = repo.FindUnprocessedMessagesMatching(outbox.Filter{TenantID: &none}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{ids[2]}, messageIDs(messages))
+}
+
+func testSkipLocked(t *testing.T, repo db.Repository) {
+	_, err := repo.CreateOutboxMessages([]outbox.Message{{Payload: []byte("a")}, {Payload: []byte("b")}})
+	require.NoError(t, err)
+
+	first := repo.BeginTransaction()
+	defer first.RollBackTransaction()
+	locked, err := first.FindUnprocessedMessages(1)
+	require.NoError(t, err)
+	require.Len(t, locked, 1)
+
+	// A concurrent relay skips the row locked by the first one
+	second := repo.BeginTransaction()
+	defer second.RollBackTransaction()
+	messages, err := second.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.NotEqual(t, locked[0].ID, messages[0].ID)
+}
+
+func testPartitionTakeover(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{{PartitionKey: "order-1"}, {PartitionKey: "order-1"}})
+	require.NoError(t, err)
+	partitions := outbox.Filter{Partitions: &outbox.PartitionSet{Count: 1, IDs: []int{0}}}
+
+	// The previous owner of the partition still holds the first message of the key
+	previous := repo.BeginTransaction()
+	defer previous.RollBackTransaction()
+	locked, err := previous.FindUnprocessedMessagesMatching(partitions, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint{ids[0]}, messageIDs(locked))
+
+	// The new owner waits for it rather than publish the second message first
+	claimed := make(chan []outbox.Message, 1)
+	errs := make(chan error, 1)
+	go func() {
+		next := repo.BeginTransaction()
+		defer next.RollBackTransaction()
+		messages, err := next.FindUnprocessedMessagesMatching(partitions, 10)
+		errs <- err
+		claimed <- messages
+	}()
+	select {
+	case <-claimed:
+		t.Fatal("the new owner skipped a message locked by the previous one")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, previous.MarkMessageAsProcessed(locked[0]))
+	require.NoError(t, previous.CommitTransaction())
+	select {
+	case messages := <-claimed:
+		require.NoError(t, <-errs)
+		assert.Equal(t, []uint{ids[1]}, messageIDs(messages))
+	case <-time.After(10 * time.Second):
+		t.Fatal("the new owner still waits after the previous one committed")
+	}
+}
+
+func testConcurrentClaims(t *testing.T, repo db.Repository) {
+	const total, workers = 40, 4
+	_, err := repo.CreateOutboxMessages(make([]outbox.Message, total))
+	require.NoError(t, err)
+
+	// Every message is leased to exactly one of the workers claiming concurrently
+	claims := map[uint]int{}
+	var mu sync.Mutex
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			for {
+				messages, err := repo.ClaimMessages(outbox.Filter{}, owner, time.Minute, 3)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(messages) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, message := range messages {
+					claims[message.ID]++
+				}
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("worker-%d", worker))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, claims, total)
+	for id, count := range claims {
+		assert.Equal(t, 1, count, "message %d claimed more than once", id)
+	}
+}
+
+func testConcurrentRelays(t *testing.T, repo db.Repository) {
+	const total, relays = 40, 4
+	_, err := repo.CreateOutboxMessages(make([]outbox.Message, total))
+	require.NoError(t, err)
+
+	// Relays holding their batch locked in a transaction until it is marked processed publish every message once
+	published := map[uint]int{}
+	var mu sync.Mutex
+	errs := make(chan error, relays)
+	var wg sync.WaitGroup
+	for relay := 0; relay < relays; relay++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				tx := repo.BeginTransaction()
+				messages, err := tx.FindUnprocessedMessages(5)
+				if err == nil && len(messages) > 0 {
+					err = tx.MarkMessagesAsProcessed(messageIDs(messages))
+				}
+				if err != nil || len(messages) == 0 {
+					_ = tx.RollBackTransaction()
+					errs <- err
+					return
+				}
+				if err = tx.CommitTransaction(); err != nil {
+					errs <- err
+					return
+				}
+				mu.Lock()
+				for _, message := range messages {
+					published[message.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, published, total)
+	for id, count := range published {
+		assert.Equal(t, 1, count, "message %d published more than once", id)
+	}
+	remaining, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+}
+
+func testRollback(t *testing.T, repo db.Repository) {
+	tx := repo.BeginTransaction()
+	require.NoError(t, tx.CreateOutboxMessage(outbox.Message{Payload: []byte("rolled back")}))
+	require.NoError(t, tx.RollBackTransaction())
+
+	tx = repo.BeginTransaction()
+	require.NoError(t, tx.CreateOutboxMessage(outbox.Message{Payload: []byte("committed")}))
+	require.NoError(t, tx.CommitTransaction())
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, []byte("committed"), messages[0].Payload)
+
+	// A relay rolling back leaves its batch pending and unlocked for the next one
+	relay := repo.BeginTransaction()
+	locked, err := relay.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, locked, 1)
+	require.NoError(t, relay.MarkMessagesAsProcessed(messageIDs(locked)))
+	require.NoError(t, relay.RollBackTransaction())
+
+	relay = repo.BeginTransaction()
+	defer relay.RollBackTransaction()
+	messages, err = relay.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, outbox.StatusPending, messages[0].Status)
+}
+
+func testMarkProcessed(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{{}, {}, {}, {}})
+	require.NoError(t, err)
+
+	require.NoError(t, repo.MarkMessageAsProcessed(outbox.Message{ID: ids[0]}))
+	require.NoError(t, repo.MarkMessagesAsProcessed(ids[1:3]))
+	require.NoError(t, repo.MarkMessagesAsProcessed(nil))
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{ids[3]}, messageIDs(messages))
+
+	require.NoError(t, repo.MarkMessageAsExpired(messages[0]))
+	messages, err = repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func testClaimAndRelease(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{{Payload: []byte("a")}, {Payload: []byte("b")}, {Payload: []byte("c")}})
+	require.NoError(t, err)
+
+	claimed, err := repo.ClaimMessages(outbox.Filter{}, "worker-1", time.Minute, 2)
+	require.NoError(t, err)
+	require.Equal(t, ids[:2], messageIDs(claimed))
+	for _, message := range claimed {
+		assert.Equal(t, outbox.StatusInFlight, message.Status)
+		assert.Equal(t, "worker-1", message.LeaseOwner)
+		assert.Equal(t, 1, message.Attempts)
+		assert.True(t, message.LeaseExpiresAt.After(time.Now()))
+	}
+
+	// Claimed messages are not handed out again while leased
+	others, err := repo.ClaimMessages(outbox.Filter{}, "worker-2", time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, ids[2:], messageIDs(others))
+
+	// A release by another owner is ignored, the owner's release makes the message pending again
+	stolen := claimed[0]
+	stolen.LeaseOwner = "worker-2"
+	require.NoError(t, repo.ReleaseMessage(stolen, time.Now().Add(-time.Second), "not mine"))
+	require.NoError(t, repo.ReleaseMessage(claimed[1], time.Now().Add(-time.Second), "publish failed"))
+
+	messages, err := repo.ListMessages(outbox.Query{Status: outbox.StatusPending})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, ids[1], messages[0].ID)
+	assert.Equal(t, "publish failed", messages[0].LastError)
+	assert.Empty(t, messages[0].LeaseOwner)
+
+	again, err := repo.ClaimMessages(outbox.Filter{}, "worker-1", time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, again, 1)
+	assert.Equal(t, 2, again[0].Attempts)
+}
+
+func testRetryBookkeeping(t *testing.T, repo db.Repository) {
+	_, err := repo.CreateOutboxMessages([]outbox.Message{{Payload: []byte("flaky")}})
+	require.NoError(t, err)
+
+	claimed, err := repo.ClaimMessages(outbox.Filter{}, "worker", time.Minute, 1)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	// A failed message waits for its retry time, keeping its attempts and the error
+	retryAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	require.NoError(t, repo.ReleaseMessage(claimed[0], retryAt, "connection reset"))
+	none, err := repo.ClaimMessages(outbox.Filter{}, "worker", time.Minute, 1)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	messages, err := repo.ListMessages(outbox.Query{})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, outbox.StatusPending, messages[0].Status)
+	assert.Equal(t, 1, messages[0].Attempts)
+	assert.Equal(t, "connection reset", messages[0].LastError)
+	assert.WithinDuration(t, retryAt, messages[0].DeliverAfter, time.Millisecond)
+	assert.Empty(t, messages[0].LeaseOwner)
+	assert.True(t, messages[0].LeaseExpiresAt.IsZero())
+
+	// Releasing a message that is no longer in flight changes nothing
+	require.NoError(t, repo.ReleaseMessage(claimed[0], time.Now(), "late"))
+	messages, err = repo.ListMessages(outbox.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, "connection reset", messages[0].LastError)
+	assert.WithinDuration(t, retryAt, messages[0].DeliverAfter, time.Millisecond)
+}
+
+func testExpiredLeases(t *testing.T, repo db.Repository) {
+	_, err := repo.CreateOutboxMessages([]outbox.Message{{}, {}})
+	require.NoError(t, err)
+	_, err = repo.ClaimMessages(outbox.Filter{}, "crashed", time.Millisecond, 1)
+	require.NoError(t, err)
+	_, err = repo.ClaimMessages(outbox.Filter{}, "alive", time.Hour, 1)
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	released, err := repo.ReleaseExpiredLeases()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), released)
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, 1, messages[0].Attempts)
+}
+
+func testMarkLeased(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{{}, {}, {}})
+	require.NoError(t, err)
+	_, err = repo.ClaimMessages(outbox.Filter{}, "stalled", time.Millisecond, 2)
+	require.NoError(t, err)
+
+	// The stalled relay's leases expire and the messages are claimed again by another one
+	time.Sleep(50 * time.Millisecond)
+	_, err = repo.ReleaseExpiredLeases()
+	require.NoError(t, err)
+	claimed, err := repo.ClaimMessages(outbox.Filter{}, "current", time.Hour, 1)
+	require.NoError(t, err)
+	require.Equal(t, ids[:1], messageIDs(claimed))
+
+	// Only the messages still leased to the relay are marked: neither the one leased to another
+	// relay, nor the one returned to pending, nor one it never claimed
+	marked, err := repo.MarkLeasedMessagesAsProcessed("stalled", ids)
+	require.NoError(t, err)
+	assert.Zero(t, marked)
+	marked, err = repo.MarkLeasedMessagesAsProcessed("current", ids)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), marked)
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	assert.Equal(t, ids[1:], messageIDs(messages))
+}
+
+func testCleanup(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{{}, {}, {}, {ExpiresAt: time.Now().Add(-time.Minute)}})
+	require.NoError(t, err)
+	claimed, err := repo.ClaimMessages(outbox.Filter{}, "worker", time.Hour, 1)
+	require.NoError(t, err)
+	require.Equal(t, ids[:1], messageIDs(claimed))
+	require.NoError(t, repo.MarkMessagesAsProcessed(ids[2:3]))
+	require.NoError(t, repo.MarkMessageAsExpired(outbox.Message{ID: ids[3]}))
+
+	// Only messages finished before the cutoff are deleted
+	deleted, err := repo.DeleteProcessedMessages(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	// A rolled back delete keeps the messages
+	tx := repo.BeginTransaction()
+	deleted, err = tx.DeleteProcessedMessages(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	require.NoError(t, tx.RollBackTransaction())
+	messages, err := repo.ListMessages(outbox.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, ids, messageIDs(messages))
+
+	// Processed and expired messages are removed from the outbox, in-flight and pending ones survive
+	deleted, err = repo.DeleteProcessedMessages(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	messages, err = repo.ListMessages(outbox.Query{})
+	require.NoError(t, err)
+	require.Equal(t, ids[:2], messageIDs(messages))
+	assert.Equal(t, outbox.StatusInFlight, messages[0].Status)
+	assert.Equal(t, outbox.StatusPending, messages[1].Status)
+
+	deleted, err = repo.DeleteProcessedMessages(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
+
+func testListAndStats(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{{TenantID: "acme"}, {TenantID: "acme"}, {TenantID: "acme"}, {}})
+	require.NoError(t, err)
+	require.NoError(t, repo.MarkMessagesAsProcessed(ids[:1]))
+
+	acme := "acme"
+	page, err := repo.ListMessages(outbox.Query{TenantID: &acme, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, ids[:2], messageIDs(page))
+	page, err = repo.ListMessages(outbox.Query{TenantID: &acme, AfterID: page[1].ID, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, ids[2:3], messageIDs(page))
+
+	stats, err := repo.MessageStats(outbox.Query{})
+	require.NoError(t, err)
+	require.Len(t, stats, 3)
+	assert.Equal(t, outbox.Stats{TenantID: "", Status: outbox.StatusPending, Count: 1}, withoutTime(stats[0]))
+	assert.Equal(t, outbox.Stats{TenantID: "acme", Status: outbox.StatusPending, Count: 2}, withoutTime(stats[1]))
+	assert.Equal(t, outbox.Stats{TenantID: "acme", Status: outbox.StatusProcessed, Count: 1}, withoutTime(stats[2]))
+	assert.False(t, stats[1].OldestCreatedAt.IsZero())
+}
+
+func testEncryptedPayload(t *testing.T, repo db.Repository) {
+	// An encrypted message is inserted without its payload, which is encrypted once it has its id
+	tx := repo.BeginTransaction()
+	ids, err := tx.CreateOutboxMessages([]outbox.Message{{ContentType: "application/json", EncryptionKeyID: "k1"}})
+	require.NoError(t, err)
+	require.NoError(t, tx.UpdateMessagePayload(outbox.Message{
+		ID: ids[0], Payload: []byte("ciphertext"), EncryptedKey: []byte("wrapped"), EncryptionKeyID: "k2",
+	}))
+	require.NoError(t, tx.CommitTransaction())
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, []byte("ciphertext"), messages[0].Payload)
+	assert.Equal(t, []byte("wrapped"), messages[0].EncryptedKey)
+	assert.Equal(t, "k2", messages[0].EncryptionKeyID)
+}
+
+func testReencrypt(t *testing.T, repo db.Repository) {
+	ids, err := repo.CreateOutboxMessages([]outbox.Message{
+		{Payload: []byte("plaintext")},
+		{Payload: []byte("ciphertext"), EncryptedKey: []byte("wrapped"), EncryptionKeyID: "k2"},
+		{Payload: []byte("ciphertext"), EncryptedKey: []byte("wrapped"), EncryptionKeyID: "k1"},
+	})
+	require.NoError(t, err)
+
+	// Only the messages encrypted with a retired key are rewrapped, plaintext ones are left alone
+	tx := repo.BeginTransaction()
+	messages, err := tx.FindMessagesToReencrypt("k2", 10)
+	require.NoError(t, err)
+	require.Equal(t, ids[2:], messageIDs(messages))
+	assert.Equal(t, "k1", messages[0].EncryptionKeyID)
+	assert.Equal(t, []byte("wrapped"), messages[0].EncryptedKey)
+
+	messages[0].EncryptionKeyID = "k2"
+	messages[0].EncryptedKey = []byte("rewrapped")
+	require.NoError(t, tx.UpdateMessageEncryption(messages[0]))
+	require.NoError(t, tx.CommitTransaction())
+
+	messages, err = repo.FindMessagesToReencrypt("k2", 10)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func messageIDs(messages []outbox.Message) []uint {
+	ids := make([]uint, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	return ids
+}
+
+func withoutTime(stats outbox.Stats) outbox.Stats {
+	stats.OldestCreatedAt = time.Time{}
+	return stats
+}
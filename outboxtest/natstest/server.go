@@ -0,0 +1,108 @@
+// Package natstest runs an in-process NATS server, so publishers and relays can be tested
+// against real NATS, core and JetStream, without external services.
+package natstest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// readyTimeout bounds how long a starting server may take to accept connections
+const readyTimeout = 10 * time.Second
+
+// Option configures the test server
+type Option func(*server.Options)
+
+// WithJetStream enables JetStream, storing streams in a temporary directory removed after the test
+func WithJetStream() Option {
+	return func(o *server.Options) {
+		o.JetStream = true
+	}
+}
+
+// WithMaxPayload sets the largest message, headers included, the server accepts
+func WithMaxPayload(maxPayload int32) Option {
+	return func(o *server.Options) {
+		o.MaxPayload = maxPayload
+	}
+}
+
+// Server is an in-process NATS server listening on a random local port
+type Server struct {
+	*server.Server
+	options *server.Options
+}
+
+// RunServer starts a NATS server that is shut down when the test ends
+func RunServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	options := &server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.JetStream {
+		options.StoreDir = t.TempDir()
+	}
+
+	s := &Server{Server: start(t, options), options: options}
+	// Restarts must find the server on the port it was given
+	s.options.Port = s.Addr().(*net.TCPAddr).Port
+	t.Cleanup(func() { s.Server.Shutdown() })
+	return s
+}
+
+// Restart shuts the server down, disconnecting its clients, and starts it again on the same port
+// with the JetStream streams it stored
+func (s *Server) Restart(t testing.TB) {
+	t.Helper()
+	s.Server.Shutdown()
+	s.Server.WaitForShutdown()
+	s.Server = start(t, s.options.Clone())
+}
+
+// Connect connects a client to the server, closed when the test ends
+func (s *Server) Connect(t testing.TB, opts ...nats.Option) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(s.ClientURL(), opts...)
+	if err != nil {
+		t.Fatalf("connecting to the test NATS server: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// AddStream creates a JetStream stream storing the messages published to the subjects
+func (s *Server) AddStream(t testing.TB, name string, subjects ...string) {
+	t.Helper()
+	js, err := s.Connect(t).JetStream()
+	if err != nil {
+		t.Fatalf("creating a JetStream context: %v", err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: name, Subjects: subjects}); err != nil {
+		t.Fatalf("adding stream %s: %v", name, err)
+	}
+}
+
+// start starts a server with the options and waits until it accepts connections
+func start(t testing.TB, options *server.Options) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(options)
+	if err != nil {
+		t.Fatalf("creating the test NATS server: %v", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(readyTimeout) {
+		s.Shutdown()
+		t.Fatalf("test NATS server not ready after %v", readyTimeout)
+	}
+	return s
+}
@@ -0,0 +1,126 @@
+package outboxtest
+
+import (
+	"sync"
+
+	publisher "github.com/outbox-go-sdk/internal/publisher/nats"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultMaxPayload is the max payload of the publisher, the default of a NATS server
+const DefaultMaxPayload = 1024 * 1024
+
+// Publisher is an in-memory publisher.Publisher that records the messages it publishes
+type Publisher struct {
+	mu         sync.Mutex
+	messages   []publisher.Message
+	fail       func(message publisher.Message) error
+	maxPayload int64
+	closed     bool
+}
+
+// NewPublisher creates a publisher accepting every message
+func NewPublisher() *Publisher {
+	return &Publisher{maxPayload: DefaultMaxPayload}
+}
+
+// Messages returns the messages published so far, in order
+func (p *Publisher) Messages() []publisher.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]publisher.Message(nil), p.messages...)
+}
+
+// Reset forgets the messages published so far
+func (p *Publisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = nil
+}
+
+// FailWith makes publishing a message fail with the error fail returns for it. A nil error publishes
+// the message, a nil fail function publishes every message again.
+func (p *Publisher) FailWith(fail func(message publisher.Message) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fail = fail
+}
+
+// SetMaxPayload sets the largest message, headers included, the publisher reports it accepts
+func (p *Publisher) SetMaxPayload(maxPayload int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxPayload = maxPayload
+}
+
+// PublishMessage records a message without headers
+func (p *Publisher) PublishMessage(subject string, data []byte) error {
+	return p.publish(publisher.Message{Subject: subject, Data: data})
+}
+
+// PublishMessageWithHeaders records a message with its headers
+func (p *Publisher) PublishMessageWithHeaders(subject string, data []byte, headers map[string]string) error {
+	return p.publish(publisher.Message{Subject: subject, Data: data, Headers: headers})
+}
+
+// PublishBatch records the messages and returns one error per message, nil for those published
+func (p *Publisher) PublishBatch(messages []publisher.Message) []error {
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		errs[i] = p.publish(message)
+	}
+	return errs
+}
+
+// Flush returns nil unless the publisher is closed, messages are recorded as soon as they are published
+func (p *Publisher) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nats.ErrConnectionClosed
+	}
+	return nil
+}
+
+// MaxPayload returns the largest message, headers included, the publisher accepts
+func (p *Publisher) MaxPayload() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxPayload
+}
+
+// Close makes later publishes fail with nats.ErrConnectionClosed
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+// publish records a copy of the message unless the publisher is closed or fails it
+func (p *Publisher) publish(message publisher.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nats.ErrConnectionClosed
+	}
+	message.Data = cloneBytes(message.Data)
+	if message.Headers != nil {
+		headers := make(map[string]string, len(message.Headers))
+		for key, value := range message.Headers {
+			headers[key] = value
+		}
+		message.Headers = headers
+	}
+	if p.fail != nil {
+		if err := p.fail(message); err != nil {
+			return err
+		}
+	}
+	if publisher.MessageSize(message.Data, message.Headers) > p.maxPayload {
+		return nats.ErrMaxPayload
+	}
+	p.messages = append(p.messages, message)
+	return nil
+}
@@ -0,0 +1,79 @@
+package outboxtest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+	"github.com/outbox-go-sdk/internal/outbox/service"
+	publisher "github.com/outbox-go-sdk/internal/publisher/nats"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_EnqueueAndProcess(t *testing.T) {
+	repo := NewRepository()
+	pub := NewPublisher()
+	svc := service.NewService(repo, pub, 10)
+
+	require.NoError(t, svc.EnqueueMessage(outbox.Message{
+		Payload:     []byte(`{"order":1}`),
+		ContentType: "application/json",
+		Headers:     outbox.Headers{"Trace-Id": "abc"},
+	}))
+	require.NoError(t, svc.CreateOutboxMessage("plain"))
+	require.NoError(t, svc.ProcessOutboxMessages())
+
+	assert.Equal(t, []publisher.Message{
+		{Subject: "outbox", Data: []byte(`{"order":1}`), Headers: map[string]string{"Trace-Id": "abc", publisher.ContentTypeHeader: "application/json"}},
+		{Subject: "outbox", Data: []byte("plain")},
+	}, pub.Messages())
+	for _, message := range repo.Messages() {
+		assert.Equal(t, outbox.StatusProcessed, message.Status)
+		assert.False(t, message.ProcessedAt.IsZero())
+	}
+}
+
+func TestService_Failure_PublishError(t *testing.T) {
+	repo := NewRepository()
+	pub := NewPublisher()
+	svc := service.NewService(repo, pub, 10, service.WithRetryPolicy(service.RetryPolicy{MaxAttempts: 1}))
+	require.NoError(t, svc.CreateOutboxMessage("payload"))
+
+	pub.FailWith(func(publisher.Message) error { return errors.New("nats error") })
+	assert.Error(t, svc.ProcessOutboxMessages())
+	assert.Empty(t, pub.Messages())
+	assert.Equal(t, outbox.StatusPending, repo.Messages()[0].Status)
+
+	// The rolled back transaction released the message for the next run
+	pub.FailWith(nil)
+	require.NoError(t, svc.ProcessOutboxMessages())
+	assert.Len(t, pub.Messages(), 1)
+	assert.Equal(t, outbox.StatusProcessed, repo.Messages()[0].Status)
+}
+
+func TestPublisher_Failure_Closed(t *testing.T) {
+	pub := NewPublisher()
+	pub.Close()
+
+	assert.ErrorIs(t, pub.PublishMessage("outbox", []byte("payload")), nats.ErrConnectionClosed)
+	assert.ErrorIs(t, pub.Flush(), nats.ErrConnectionClosed)
+	assert.Equal(t, []error{nats.ErrConnectionClosed}, pub.PublishBatch([]publisher.Message{{Subject: "outbox"}}))
+	assert.Empty(t, pub.Messages())
+}
+
+func TestPublisher_Failure_MaxPayloadCountsHeaders(t *testing.T) {
+	pub := NewPublisher()
+	// 20 bytes of data, 12 bytes of header preamble and 6 bytes for "A: b\r\n"
+	pub.SetMaxPayload(37)
+	data := make([]byte, 20)
+	headers := map[string]string{"A": "b"}
+
+	assert.NoError(t, pub.PublishMessage("outbox", data))
+	assert.ErrorIs(t, pub.PublishMessageWithHeaders("outbox", data, headers), nats.ErrMaxPayload)
+	pub.SetMaxPayload(38)
+	assert.NoError(t, pub.PublishMessageWithHeaders("outbox", data, headers))
+	assert.Len(t, pub.Messages(), 2)
+}
@@ -0,0 +1,716 @@
+// Package outboxtest provides in-memory implementations of the outbox storage and publisher,
+// so code using the outbox can be tested end-to-end without PostgreSQL or NATS, and the
+// contract every storage backend of the outbox passes.
+package outboxtest
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+)
+
+var (
+	// ErrNoTransaction is returned when committing or rolling back outside a transaction
+	ErrNoTransaction = errors.New("outboxtest: not in a transaction")
+	// ErrTransactionDone is returned when using a transaction that was already committed or rolled back
+	ErrTransactionDone = errors.New("outboxtest: transaction has already been committed or rolled back")
+)
+
+// Option configures optional behaviour of the repository
+type Option func(*store)
+
+// WithClock sets the clock the repository reads the current time from, e.g. to make messages due
+// or leases expire without sleeping
+func WithClock(now func() time.Time) Option {
+	return func(s *store) {
+		s.now = now
+	}
+}
+
+// Repository is an in-memory db.Repository. It behaves like the PostgreSQL repository: transactions
+// only publish their writes when committed, the rows they read for publishing or write stay locked
+// until they end, and concurrent relays skip locked rows. Writes to a row locked by another transaction
+// wait until it ends. Transactions begun inside a transaction are savepoints.
+type Repository struct {
+	store *store
+	// tx is the transaction the repository runs in, nil outside one
+	tx *transaction
+}
+
+// store holds the committed messages and row locks shared by a repository and its transactions
+type store struct {
+	mu sync.Mutex
+	// unlocked is signalled whenever a transaction ends and releases its locks
+	unlocked *sync.Cond
+	messages map[uint]outbox.Message
+	locks    map[uint]*transaction
+	lastID   uint
+	now      func() time.Time
+}
+
+// transaction holds the uncommitted writes and deletes of a transaction or savepoint
+type transaction struct {
+	parent  *transaction
+	writes  map[uint]outbox.Message
+	deleted map[uint]bool
+	done    bool
+}
+
+// root returns the top-level transaction, which holds the row locks of its savepoints
+func (tx *transaction) root() *transaction {
+	for tx.parent != nil {
+		tx = tx.parent
+	}
+	return tx
+}
+
+// NewRepository creates an empty in-memory repository
+func NewRepository(opts ...Option) *Repository {
+	s := &store{
+		messages: map[uint]outbox.Message{},
+		locks:    map[uint]*transaction{},
+		now:      time.Now,
+	}
+	s.unlocked = sync.NewCond(&s.mu)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return &Repository{store: s}
+}
+
+// Messages returns all committed messages ordered by id, whatever their status
+func (r *Repository) Messages() []outbox.Message {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	messages := make([]outbox.Message, 0, len(r.store.messages))
+	for _, message := range r.store.messages {
+		messages = append(messages, clone(message))
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages
+}
+
+// Message returns the committed message with the given id
+func (r *Repository) Message(id uint) (outbox.Message, bool) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	message, ok := r.store.messages[id]
+	return clone(message), ok
+}
+
+// BeginTransaction starts a new transaction, or a savepoint when the repository is in one
+func (r *Repository) BeginTransaction() db.Repository {
+	return &Repository{store: r.store, tx: &transaction{parent: r.tx, writes: map[uint]outbox.Message{}, deleted: map[uint]bool{}}}
+}
+
+// CommitTransaction publishes the writes of the transaction, or hands those of a savepoint to its parent
+func (r *Repository) CommitTransaction() error {
+	return r.end(func(tx *transaction) {
+		if tx.parent != nil {
+			for id, message := range tx.writes {
+				tx.parent.writes[id] = message
+			}
+			for id := range tx.deleted {
+				delete(tx.parent.writes, id)
+				tx.parent.deleted[id] = true
+			}
+			return
+		}
+		for id, message := range tx.writes {
+			r.store.messages[id] = message
+		}
+		for id := range tx.deleted {
+			delete(r.store.messages, id)
+		}
+	})
+}
+
+// RollBackTransaction discards the writes of the transaction or savepoint
+func (r *Repository) RollBackTransaction() error {
+	return r.end(func(*transaction) {})
+}
+
+// end finishes the transaction, releasing its row locks unless it is a savepoint
+func (r *Repository) end(finish func(tx *transaction)) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.tx == nil {
+		return ErrNoTransaction
+	}
+	if r.tx.done {
+		return ErrTransactionDone
+	}
+	finish(r.tx)
+	r.tx.done = true
+	if r.tx.parent == nil {
+		for id, owner := range s.locks {
+			if owner == r.tx {
+				delete(s.locks, id)
+			}
+		}
+		s.unlocked.Broadcast()
+	}
+	return nil
+}
+
+// CreateOutboxMessage adds a new message to the outbox
+func (r *Repository) CreateOutboxMessage(message outbox.Message) error {
+	_, err := r.CreateOutboxMessages([]outbox.Message{message})
+	return err
+}
+
+// CreateOutboxMessages adds the messages to the outbox and returns their ids in the same order
+func (r *Repository) CreateOutboxMessages(messages []outbox.Message) ([]uint, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.usable(); err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, len(messages))
+	now := s.now()
+	for i, message := range messages {
+		// Like a sequence, ids taken by a transaction that is rolled back are not reused
+		s.lastID++
+		message = clone(message)
+		message.ID = s.lastID
+		if message.Status == "" {
+			message.Status = outbox.StatusPending
+		}
+		if message.DeliverAfter.IsZero() {
+			message.DeliverAfter = now
+		}
+		if message.CreatedAt.IsZero() {
+			message.CreatedAt = now
+		}
+		if message.UpdatedAt.IsZero() {
+			message.UpdatedAt = now
+		}
+		if len(message.Headers) == 0 {
+			message.Headers = nil
+		}
+		r.put(message)
+		ids[i] = message.ID
+	}
+	return ids, nil
+}
+
+// FindUnprocessedMessages retrieves unprocessed outbox messages that are due, in batches.
+// The messages stay locked until the transaction ends and are skipped by concurrent relays.
+func (r *Repository) FindUnprocessedMessages(batchSize int) ([]outbox.Message, error) {
+	return r.FindUnprocessedMessagesMatching(outbox.Filter{}, batchSize)
+}
+
+// FindUnprocessedMessagesMatching retrieves unprocessed outbox messages that are due and match the filter.
+// Messages restricted to a priority range are returned highest priority first.
+func (r *Repository) FindUnprocessedMessagesMatching(filter outbox.Filter, batchSize int) ([]outbox.Message, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.usable(); err != nil {
+		return nil, err
+	}
+	messages := r.pending(filter, batchSize)
+	for _, message := range messages {
+		r.lock(message.ID)
+	}
+	return messages, nil
+}
+
+// FindTenantsWithPendingMessages returns the tenants with due messages matching the filter, sorted,
+// the empty tenant standing for messages without one
+func (r *Repository) FindTenantsWithPendingMessages(filter outbox.Filter) ([]string, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.usable(); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var tenants []string
+	now := s.now()
+	for _, message := range r.visible() {
+		if due(message, filter, now) && !seen[message.TenantID] {
+			seen[message.TenantID] = true
+			tenants = append(tenants, message.TenantID)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// ClaimMessages leases due messages matching the filter to the owner, moving them to the in-flight status
+// until the lease expires. Messages are returned in the order FindUnprocessedMessagesMatching uses.
+func (r *Repository) ClaimMessages(filter outbox.Filter, owner string, lease time.Duration, batchSize int) ([]outbox.Message, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.usable(); err != nil {
+		return nil, err
+	}
+	messages := r.pending(filter, batchSize)
+	leaseExpiresAt := s.now().Add(lease)
+	for i := range messages {
+		messages[i].Status = outbox.StatusInFlight
+		messages[i].LeaseOwner = owner
+		messages[i].LeaseExpiresAt = leaseExpiresAt
+		messages[i].Attempts++
+		r.lock(messages[i].ID)
+		r.put(messages[i])
+	}
+	return cloneAll(messages), nil
+}
+
+// ReleaseMessage returns an in-flight message that failed to publish to pending, due again at retryAt.
+// It does nothing if the lease was lost to the reaper in the meantime.
+func (r *Repository) ReleaseMessage(message outbox.Message, retryAt time.Time, lastError string) error {
+	_, err := r.update(func(stored outbox.Message) bool {
+		return stored.ID == message.ID && stored.Status == outbox.StatusInFlight && stored.LeaseOwner == message.LeaseOwner
+	}, func(stored *outbox.Message) {
+		stored.Status = outbox.StatusPending
+		stored.LeaseOwner = ""
+		stored.LeaseExpiresAt = time.Time{}
+		stored.DeliverAfter = retryAt
+		stored.LastError = lastError
+	})
+	return err
+}
+
+// ReleaseExpiredLeases returns in-flight messages whose lease expired to pending, so they are published
+// again. It returns the number of released messages.
+func (r *Repository) ReleaseExpiredLeases() (int64, error) {
+	now := r.now()
+	return r.update(func(stored outbox.Message) bool {
+		return stored.Status == outbox.StatusInFlight && stored.LeaseExpiresAt.Before(now)
+	}, func(stored *outbox.Message) {
+		stored.Status = outbox.StatusPending
+		stored.LeaseOwner = ""
+		stored.LeaseExpiresAt = time.Time{}
+	})
+}
+
+// MarkMessageAsProcessed marks a message as processed
+func (r *Repository) MarkMessageAsProcessed(message outbox.Message) error {
+	return r.MarkMessagesAsProcessed([]uint{message.ID})
+}
+
+// MarkMessagesAsProcessed marks all messages with the given ids as processed
+func (r *Repository) MarkMessagesAsProcessed(ids []uint) error {
+	now := r.now()
+	_, err := r.update(withIDs(ids), func(stored *outbox.Message) {
+		stored.Status = outbox.StatusProcessed
+		stored.ProcessedAt = now
+	})
+	return err
+}
+
+// MarkLeasedMessagesAsProcessed marks the messages with the given ids still leased to the owner as processed,
+// leaving alone those whose lease was lost. It returns the number of messages marked.
+func (r *Repository) MarkLeasedMessagesAsProcessed(owner string, ids []uint) (int64, error) {
+	now := r.now()
+	selected := withIDs(ids)
+	return r.update(func(message outbox.Message) bool {
+		return selected(message) && message.Status == outbox.StatusInFlight && message.LeaseOwner == owner
+	}, func(stored *outbox.Message) {
+		stored.Status = outbox.StatusProcessed
+		stored.ProcessedAt = now
+	})
+}
+
+// MarkMessageAsExpired moves a message that expired before being published to the expired status
+func (r *Repository) MarkMessageAsExpired(message outbox.Message) error {
+	_, err := r.update(withIDs([]uint{message.ID}), func(stored *outbox.Message) {
+		stored.Status = outbox.StatusExpired
+	})
+	return err
+}
+
+// DeleteProcessedMessages deletes the messages processed, or expired, before the given time.
+// Pending and in-flight messages are never deleted. It returns the number of deleted messages.
+func (r *Repository) DeleteProcessedMessages(before time.Time) (int64, error) {
+	return r.remove(func(stored outbox.Message) bool {
+		switch stored.Status {
+		case outbox.StatusProcessed:
+			return stored.ProcessedAt.Before(before)
+		case outbox.StatusExpired:
+			return !stored.ExpiresAt.IsZero() && stored.ExpiresAt.Before(before)
+		default:
+			return false
+		}
+	})
+}
+
+// FindMessagesToReencrypt retrieves and locks messages encrypted with a key other than the current one
+func (r *Repository) FindMessagesToReencrypt(currentKeyID string, batchSize int) ([]outbox.Message, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.usable(); err != nil {
+		return nil, err
+	}
+	var messages []outbox.Message
+	for _, message := range r.visible() {
+		if len(messages) == batchSize {
+			break
+		}
+		if message.EncryptionKeyID != "" && message.EncryptionKeyID != currentKeyID && !r.lockedByOther(message.ID) {
+			r.lock(message.ID)
+			messages = append(messages, outbox.Message{
+				ID:              message.ID,
+				EncryptionKeyID: message.EncryptionKeyID,
+				EncryptedKey:    append([]byte(nil), message.EncryptedKey...),
+			})
+		}
+	}
+	return messages, nil
+}
+
+// UpdateMessageEncryption stores a rewrapped data key and its key id
+func (r *Repository) UpdateMessageEncryption(message outbox.Message) error {
+	_, err := r.update(withIDs([]uint{message.ID}), func(stored *outbox.Message) {
+		stored.EncryptionKeyID = message.EncryptionKeyID
+		stored.EncryptedKey = append([]byte(nil), message.EncryptedKey...)
+	})
+	return err
+}
+
+// UpdateMessagePayload stores the encrypted payload of a message inserted before its id was known,
+// together with its wrapped data key and key id
+func (r *Repository) UpdateMessagePayload(message outbox.Message) error {
+	_, err := r.update(withIDs([]uint{message.ID}), func(stored *outbox.Message) {
+		stored.Payload = append([]byte(nil), message.Payload...)
+		stored.EncryptionKeyID = message.EncryptionKeyID
+		stored.EncryptedKey = append([]byte(nil), message.EncryptedKey...)
+	})
+	return err
+}
+
+// ListMessages returns the messages matching the query ordered by id, for admin tooling
+func (r *Repository) ListMessages(query outbox.Query) ([]outbox.Message, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.usable(); err != nil {
+		return nil, err
+	}
+	var messages []outbox.Message
+	for _, message := range r.visible() {
+		if query.Limit > 0 && len(messages) == query.Limit {
+			break
+		}
+		if message.ID > query.AfterID && matches(message, query) {
+			messages = append(messages, message)
+		}
+	}
+	return cloneAll(messages), nil
+}
+
+// MessageStats counts the messages matching the query by tenant and status
+func (r *Repository) MessageStats(query outbox.Query) ([]outbox.Stats, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.usable(); err != nil {
+		return nil, err
+	}
+	type group struct{ tenantID, status string }
+	counts := map[group]*outbox.Stats{}
+	var stats []outbox.Stats
+	for _, message := range r.visible() {
+		if !matches(message, query) {
+			continue
+		}
+		key := group{message.TenantID, message.Status}
+		if counts[key] == nil {
+			counts[key] = &outbox.Stats{TenantID: message.TenantID, Status: message.Status, OldestCreatedAt: message.CreatedAt}
+		}
+		counts[key].Count++
+		if message.CreatedAt.Before(counts[key].OldestCreatedAt) {
+			counts[key].OldestCreatedAt = message.CreatedAt
+		}
+	}
+	for _, count := range counts {
+		stats = append(stats, *count)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].TenantID != stats[j].TenantID {
+			return stats[i].TenantID < stats[j].TenantID
+		}
+		return stats[i].Status < stats[j].Status
+	})
+	return stats, nil
+}
+
+// now reads the repository's clock
+func (r *Repository) now() time.Time {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	return r.store.now()
+}
+
+// usable returns an error when the repository runs in a transaction that has ended. The store lock must be held.
+func (r *Repository) usable() error {
+	for tx := r.tx; tx != nil; tx = tx.parent {
+		if tx.done {
+			return ErrTransactionDone
+		}
+	}
+	return nil
+}
+
+// visible returns the messages the repository sees ordered by id: the committed ones overlaid with
+// the writes of its transaction and the transaction's parents. The store lock must be held.
+func (r *Repository) visible() []outbox.Message {
+	latest := make(map[uint]outbox.Message, len(r.store.messages))
+	for id, message := range r.store.messages {
+		latest[id] = message
+	}
+	var chain []*transaction
+	for tx := r.tx; tx != nil; tx = tx.parent {
+		chain = append(chain, tx)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for id, message := range chain[i].writes {
+			latest[id] = message
+		}
+		for id := range chain[i].deleted {
+			delete(latest, id)
+		}
+	}
+
+	messages := make([]outbox.Message, 0, len(latest))
+	for _, message := range latest {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages
+}
+
+// pending returns up to batchSize due messages matching the filter in publishing order,
+// skipping those locked by other transactions. A sharded relay owns its partitions, so with a
+// partition filter it waits for those locks instead, like the SQL backends. The store lock must be held.
+func (r *Repository) pending(filter outbox.Filter, batchSize int) []outbox.Message {
+	for filter.Partitions != nil && r.lockedDue(filter) {
+		r.store.unlocked.Wait()
+	}
+	now := r.store.now()
+	var messages []outbox.Message
+	for _, message := range r.visible() {
+		if due(message, filter, now) && !r.lockedByOther(message.ID) {
+			messages = append(messages, message)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if filter.Priority != nil && a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.DeliverAfter.Equal(b.DeliverAfter) {
+			return a.DeliverAfter.Before(b.DeliverAfter)
+		}
+		return a.ID < b.ID
+	})
+	if len(messages) > batchSize {
+		messages = messages[:batchSize]
+	}
+	return cloneAll(messages)
+}
+
+// update applies the change to every visible message matching the condition and returns how many changed.
+// Like an UPDATE, it waits for the transactions locking the matching messages to end, then checks the
+// condition again against the message they left behind.
+func (r *Repository) update(condition func(outbox.Message) bool, change func(*outbox.Message)) (int64, error) {
+	return r.modify(condition, func(message outbox.Message) {
+		change(&message)
+		r.put(message)
+	})
+}
+
+// remove deletes every visible message matching the condition and returns how many were deleted,
+// waiting for locks like update
+func (r *Repository) remove(condition func(outbox.Message) bool) (int64, error) {
+	return r.modify(condition, func(message outbox.Message) {
+		if r.tx == nil {
+			delete(r.store.messages, message.ID)
+			return
+		}
+		delete(r.tx.writes, message.ID)
+		r.tx.deleted[message.ID] = true
+	})
+}
+
+// modify applies write to every visible message matching the condition once it holds its lock,
+// and returns how many were written
+func (r *Repository) modify(condition func(outbox.Message) bool, write func(outbox.Message)) (int64, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.usable(); err != nil {
+		return 0, err
+	}
+	var updated int64
+	for _, message := range r.visible() {
+		if !condition(message) {
+			continue
+		}
+		for r.lockedByOther(message.ID) {
+			s.unlocked.Wait()
+		}
+		// The message may have changed or been rolled back while waiting
+		current, ok := r.lookup(message.ID)
+		if !ok || !condition(current) {
+			continue
+		}
+		r.lock(current.ID)
+		write(current)
+		updated++
+	}
+	return updated, nil
+}
+
+// lookup returns the message with the given id as the repository sees it. The store lock must be held.
+func (r *Repository) lookup(id uint) (outbox.Message, bool) {
+	for tx := r.tx; tx != nil; tx = tx.parent {
+		if tx.deleted[id] {
+			return outbox.Message{}, false
+		}
+		if message, ok := tx.writes[id]; ok {
+			return message, true
+		}
+	}
+	message, ok := r.store.messages[id]
+	return message, ok
+}
+
+// lockedDue reports whether another transaction locks a due message matching the filter.
+// The store lock must be held.
+func (r *Repository) lockedDue(filter outbox.Filter) bool {
+	now := r.store.now()
+	for _, message := range r.visible() {
+		if due(message, filter, now) && r.lockedByOther(message.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// put writes the message in the repository's transaction, or commits it right away outside one.
+// The store lock must be held.
+func (r *Repository) put(message outbox.Message) {
+	if r.tx == nil {
+		r.store.messages[message.ID] = message
+		return
+	}
+	r.tx.writes[message.ID] = message
+}
+
+// lock locks the message until the repository's transaction ends, outside a transaction it does nothing.
+// The store lock must be held.
+func (r *Repository) lock(id uint) {
+	if r.tx != nil {
+		r.store.locks[id] = r.tx.root()
+	}
+}
+
+// lockedByOther reports whether another transaction locks the message. The store lock must be held.
+func (r *Repository) lockedByOther(id uint) bool {
+	owner, ok := r.store.locks[id]
+	return ok && (r.tx == nil || owner != r.tx.root())
+}
+
+// due reports whether the message is pending, due at the given time and matches the filter
+func due(message outbox.Message, filter outbox.Filter, now time.Time) bool {
+	if message.Status != outbox.StatusPending || message.DeliverAfter.After(now) {
+		return false
+	}
+	if filter.Partitions != nil && !inPartitions(message, *filter.Partitions) {
+		return false
+	}
+	if filter.TenantID != nil && message.TenantID != *filter.TenantID {
+		return false
+	}
+	return filter.Priority == nil || filter.Priority.Contains(message.Priority)
+}
+
+// inPartitions reports whether the message's partition is in the set. Partition keys are hashed
+// with CRC32 like the MySQL and SQLite backends, messages without one are spread by id.
+func inPartitions(message outbox.Message, partitions outbox.PartitionSet) bool {
+	key := message.PartitionKey
+	if key == "" {
+		key = strconv.FormatUint(uint64(message.ID), 10)
+	}
+	partition := int(crc32.ChecksumIEEE([]byte(key)) % uint32(partitions.Count))
+	for _, id := range partitions.IDs {
+		if id == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the message matches an admin query
+func matches(message outbox.Message, query outbox.Query) bool {
+	if query.TenantID != nil && message.TenantID != *query.TenantID {
+		return false
+	}
+	return query.Status == "" || message.Status == query.Status
+}
+
+// withIDs returns a condition matching the messages with the given ids
+func withIDs(ids []uint) func(outbox.Message) bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return func(message outbox.Message) bool { return set[message.ID] }
+}
+
+// clone copies the message, so that callers and the repository do not share its byte slices and headers
+func clone(message outbox.Message) outbox.Message {
+	message.Payload = cloneBytes(message.Payload)
+	message.PayloadJSON = cloneBytes(message.PayloadJSON)
+	message.EncryptedKey = cloneBytes(message.EncryptedKey)
+	if message.Headers != nil {
+		headers := make(outbox.Headers, len(message.Headers))
+		for key, value := range message.Headers {
+			headers[key] = value
+		}
+		message.Headers = headers
+	}
+	return message
+}
+
+func cloneAll(messages []outbox.Message) []outbox.Message {
+	for i := range messages {
+		messages[i] = clone(messages[i])
+	}
+	return messages
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,88 @@
+package outboxtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/outbox-go-sdk/internal/db"
+	"github.com/outbox-go-sdk/internal/domain/outbox"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_Contract(t *testing.T) {
+	RunRepositoryContract(t, func(t *testing.T) db.Repository {
+		return NewRepository()
+	})
+}
+
+func TestRepository_Savepoint(t *testing.T) {
+	repo := NewRepository()
+
+	tx := repo.BeginTransaction()
+	savepoint := tx.BeginTransaction()
+	require.NoError(t, savepoint.CreateOutboxMessage(outbox.Message{Payload: []byte("rolled back")}))
+	require.NoError(t, savepoint.RollBackTransaction())
+	savepoint = tx.BeginTransaction()
+	require.NoError(t, savepoint.CreateOutboxMessage(outbox.Message{Payload: []byte("released")}))
+	require.NoError(t, savepoint.CommitTransaction())
+	assert.Empty(t, repo.Messages(), "uncommitted messages are invisible outside the transaction")
+	require.NoError(t, tx.CommitTransaction())
+
+	messages := repo.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, []byte("released"), messages[0].Payload)
+}
+
+func TestRepository_WriteWaitsForLock(t *testing.T) {
+	repo := NewRepository()
+	require.NoError(t, repo.CreateOutboxMessage(outbox.Message{}))
+
+	tx := repo.BeginTransaction()
+	locked, err := tx.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	require.Len(t, locked, 1)
+
+	done := make(chan error)
+	go func() { done <- repo.MarkMessageAsProcessed(locked[0]) }()
+	select {
+	case <-done:
+		t.Fatal("the update did not wait for the transaction locking the message")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	require.NoError(t, tx.RollBackTransaction())
+	require.NoError(t, <-done)
+	message, ok := repo.Message(locked[0].ID)
+	require.True(t, ok)
+	assert.Equal(t, outbox.StatusProcessed, message.Status)
+}
+
+func TestRepository_WithClock(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := NewRepository(WithClock(func() time.Time { return now }))
+	require.NoError(t, repo.CreateOutboxMessage(outbox.Message{DeliverAfter: now.Add(time.Minute)}))
+
+	messages, err := repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	now = now.Add(time.Minute)
+	messages, err = repo.FindUnprocessedMessages(10)
+	require.NoError(t, err)
+	assert.Len(t, messages, 1)
+}
+
+func TestRepository_Failure_TransactionEnded(t *testing.T) {
+	repo := NewRepository()
+	assert.ErrorIs(t, repo.CommitTransaction(), ErrNoTransaction)
+	assert.ErrorIs(t, repo.RollBackTransaction(), ErrNoTransaction)
+
+	tx := repo.BeginTransaction()
+	require.NoError(t, tx.CommitTransaction())
+	assert.ErrorIs(t, tx.CommitTransaction(), ErrTransactionDone)
+	assert.ErrorIs(t, tx.CreateOutboxMessage(outbox.Message{}), ErrTransactionDone)
+	_, err := tx.FindUnprocessedMessages(10)
+	assert.ErrorIs(t, err, ErrTransactionDone)
+}
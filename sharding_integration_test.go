@@ -0,0 +1,73 @@
+//go:build integration
+// +build integration
+
+package go_transactional_outbox
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	repo "github.com/outbox-go-sdk/internal/db/postgres"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionCoordinator_JoinLeaveAndTakeover(t *testing.T) {
+	gormDB, err := setupDB()
+	require.NoError(t, err)
+	sqlDB, err := gormDB.DB()
+	require.NoError(t, err)
+
+	// Migrating the outbox table creates its relay instance and partition lease tables
+	table := contractTable()
+	_, err = repo.NewGormRepository(&repo.Config{DBInstance: gormDB, TableName: table})
+	require.NoError(t, err)
+	for _, suffix := range []string{"", "_relay_instances", "_partition_leases"} {
+		dropContractTable(t, gormDB, fmt.Sprintf("%q", table+suffix), table)
+	}
+
+	const ttl = 600 * time.Millisecond
+	newCoordinator := func(instanceID string) repo.PartitionCoordinator {
+		coordinator, err := repo.NewPartitionCoordinator(&repo.ShardingConfig{
+			DB:         sqlDB,
+			Table:      repo.Table{Name: table},
+			Partitions: 4,
+			InstanceID: instanceID,
+			LeaseTTL:   ttl,
+		})
+		require.NoError(t, err)
+		return coordinator
+	}
+	partitions := func(coordinator repo.PartitionCoordinator) []int {
+		set, err := coordinator.Partitions()
+		require.NoError(t, err)
+		assert.Equal(t, 4, set.Count)
+		return set.IDs
+	}
+	// resync waits until the coordinators renew their leases on the next call
+	resync := func() { time.Sleep(ttl/3 + 50*time.Millisecond) }
+
+	// The first instance takes every partition
+	a := newCoordinator("relay-a")
+	assert.Equal(t, []int{0, 1, 2, 3}, partitions(a))
+
+	// A joining instance only gets its share once the first one released it
+	b := newCoordinator("relay-b")
+	assert.Empty(t, partitions(b))
+	resync()
+	assert.Equal(t, []int{0, 2}, partitions(a))
+	assert.Equal(t, []int{1, 3}, partitions(b))
+
+	// Leaving hands the partitions over at the next renewal instead of after the lease TTL
+	require.NoError(t, b.Leave())
+	resync()
+	assert.Equal(t, []int{0, 1, 2, 3}, partitions(a))
+
+	// The partitions of an instance that stopped renewing are taken over once its leases expire
+	b = newCoordinator("relay-b")
+	assert.Empty(t, partitions(b))
+	time.Sleep(ttl + 100*time.Millisecond)
+	assert.Equal(t, []int{0, 1, 2, 3}, partitions(b))
+}